@@ -1,99 +1,106 @@
-// main file of execution -- package main at the top of other packages removes requirement to import here
-// http server and WebSocket initialization
+// main file of execution -- the server itself lives in the warpsockets package so other Go services can embed it
+// loads the config, starts the server and shuts it down gracefully on ctrl + c / SIGTERM
 
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
+	"github.com/gschussler/word-roulette_go/backend/warpsockets"
 )
 
+// the library serves no files unless told to, the standalone server serves the built frontend next to it
+func defaultConfig() warpsockets.Config {
+	cfg := warpsockets.DefaultConfig()
+	cfg.StaticDir = "./frontend/dist"
+	return cfg
+}
+
 // declare a channel to receive signals for graceful shutdown (ctrl + c)
 var shutdown = make(chan os.Signal, 1)
 
 func main() {
-	// handle cors
-	c := handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"}),
-		handlers.AllowedHeaders([]string{"Content-Type"}),
-	)
+	// defaults < config file < environment < flags (see warpsockets/config.go)
+	cfg, err := warpsockets.LoadConfigOver(defaultConfig(), os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
 
-	// init Redis db
-	initRedis()
+	server, err := warpsockets.New(cfg)
+	if err != nil {
+		log.Fatalf("Error creating server: %v", err)
+	}
+	logger := server.Logger()
+	logger.Info("config loaded", "config", cfg.String())
+
+	// connect to Redis and register this instance with other server replicas
+	if err := server.Start(context.Background()); err != nil {
+		logger.Error("error starting server", "err", err)
+		os.Exit(1)
+	}
 
 	// notify server of OS signals
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	router := mux.NewRouter()
-
-	// // use to help debug routing problems
-	// router.Use(loggingMiddleware)
-
-	// // server static to load fonts -- moved assets to frontend
-	// router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	// accept reqs to check lobby existence
-	router.HandleFunc("/check-lobby", checkLobbyExist).Methods("POST")
-	// accept reqs to upgrade HTTP to WebSocket connection
-	router.HandleFunc("/ws", handleWebSocket)
-	// serve frontend dir (default path always last to properly expose other routes)
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./frontend/dist")))
-
-	handler := c(router)
-
+	// re-read the config on SIGHUP and apply the settings that can change while running (the log level)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		<-shutdown
-		log.Println("SHUTDOWN SIGNAL -- Closing connections and cleaning up...")
-
-		// Delete information in the Redis key/store
-		if err := deleteRedisData(); err != nil {
-			log.Printf("Error deleting Redis data: %v", err)
+		for range reload {
+			newCfg, err := warpsockets.LoadConfigOver(defaultConfig(), os.Args[1:])
+			if err != nil {
+				logger.Error("error reloading config", "err", err)
+				continue
+			}
+			if err := server.SetLogLevel(newCfg.LogLevel); err != nil {
+				logger.Error("error applying log level", "err", err)
+			}
 		}
+	}()
 
-		// close WebSocket connections to prevent errors
-		lobbyConnections.Range(func(key, value interface{}) bool {
-			lobby := key.(string)
-			users := value.([]*LobbyUser)
-
-			// log.Printf("Closing connections for lobby: %s", lobby)
-			for _, user := range users {
-				if err := user.Conn.Close(); err != nil {
-					log.Printf("Error closing connection for user %s: %v", user.User, err)
-				}
+	// SIGUSR1 turns maintenance mode on and shows maintenance.message to everyone as a banner, SIGUSR2 undoes both
+	maintenance := make(chan os.Signal, 1)
+	signal.Notify(maintenance, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range maintenance {
+			enabled := sig == syscall.SIGUSR1
+			banner := ""
+			if enabled {
+				banner = cfg.MaintenanceMessage
 			}
-			lobbyConnections.Delete(lobby)
-
-			return true
-		})
+			if err := server.SetMaintenance(context.Background(), enabled, ""); err != nil {
+				logger.Error("error changing maintenance mode", "err", err)
+			}
+			if err := server.SetBanner(context.Background(), banner); err != nil {
+				logger.Error("error changing banner", "err", err)
+			}
+		}
+	}()
 
-		log.Println("Shutting down...")
-		os.Exit(0)
+	// drain connections and clean up on ctrl + c / SIGTERM
+	shutdownComplete := make(chan struct{})
+	go func() {
+		<-shutdown
+		logger.Info("shutdown signal, closing connections and cleaning up")
+		if err := server.Shutdown(context.Background()); err != nil {
+			logger.Error("error closing Redis client", "err", err)
+		}
+		close(shutdownComplete)
 	}()
 
-	// start http server for homepage
-	// prepare WebSocket for incoming connections
 	// should work in containerized environment -- specified only the port, not the IP
-	log.Println("server started on port 8085")
-	srv := &http.Server{
-		Addr:    ":8085",
-		Handler: handler,
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		logger.Error("error starting server", "err", err)
+		os.Exit(1)
 	}
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Fatal("Error starting server: ", err)
-	}
-}
 
-// // loggingMiddleware logs the incoming HTTP requests -- uncomment along with its router for logging
-// func loggingMiddleware(next http.Handler) http.Handler {
-// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		log.Printf("Received request: %s %s", r.Method, r.RequestURI)
-// 		next.ServeHTTP(w, r)
-// 	})
-// }
+	// ListenAndServe returns as soon as the listener closes, wait for draining to finish
+	<-shutdownComplete
+	logger.Info("shutting down")
+}
@@ -0,0 +1,314 @@
+/* Authenticated admin API for operators: list and inspect lobbies, announce, kick users, close and purge lobbies */
+package warpsockets
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+// history returned when inspecting a lobby, unless ?limit= asks for another amount
+const defaultAdminHistory = 50
+
+// A lobby as listed by GET /admin/lobbies.
+type AdminLobby struct {
+	Lobby            string    `json:"lobby"`
+	Members          int64     `json:"members"`          // across every instance
+	LocalConnections int       `json:"localConnections"` // on the instance that answered
+	Creator          string    `json:"creator,omitempty"`
+	Created          time.Time `json:"created,omitempty"`
+	Age              string    `json:"age,omitempty"`
+}
+
+// A lobby as returned by GET /admin/lobbies/{lobby}.
+type AdminLobbyDetail struct {
+	AdminLobby
+	Members []AdminMember `json:"memberList"`
+	History []Message     `json:"history"`
+}
+
+type AdminMember struct {
+	User     string `json:"user"`
+	Instance string `json:"instance"` // server instance holding the user's connection
+}
+
+// Body of POST /admin/announcements. An empty lobby announces to every lobby.
+type AdminAnnouncement struct {
+	Lobby   string `json:"lobby,omitempty"`
+	Message string `json:"message"`
+}
+
+func (s *Server) adminRoutes(router *mux.Router) {
+	router.Use(s.requireAdmin)
+	router.HandleFunc("/lobbies", s.handleAdminListLobbies).Methods("GET")
+	router.HandleFunc("/lobbies/{lobby}", s.handleAdminLobby).Methods("GET")
+	router.HandleFunc("/lobbies/{lobby}", s.handleAdminCloseLobby).Methods("DELETE")
+	router.HandleFunc("/lobbies/{lobby}/members/{user}", s.handleAdminKick).Methods("DELETE")
+	router.HandleFunc("/lobbies/{lobby}/messages", s.handleAdminPurge).Methods("DELETE")
+	router.HandleFunc("/announcements", s.handleAdminAnnounce).Methods("POST")
+	router.HandleFunc("/banner", s.handleAdminSetBanner).Methods("PUT")
+	router.HandleFunc("/banner", s.handleAdminClearBanner).Methods("DELETE")
+	router.HandleFunc("/maintenance", s.handleAdminGetMaintenance).Methods("GET")
+	router.HandleFunc("/maintenance", s.handleAdminSetMaintenance).Methods("PUT")
+	router.HandleFunc("/archives", s.handleAdminListArchives).Methods("GET")
+	router.HandleFunc("/archives/{id}", s.handleAdminArchive).Methods("GET")
+}
+
+// requireAdmin only lets requests bearing admin.token through
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+			s.logger.Warn("rejected admin request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, Response{Type: "error", Message: "Missing or invalid admin token."})
+			return
+		}
+		s.logger.Info("admin request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleAdminListLobbies(w http.ResponseWriter, r *http.Request) {
+	lobbies, err := s.listLobbies(r.Context())
+	if err != nil {
+		s.logger.Error("error listing lobbies", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to list lobbies."})
+		return
+	}
+
+	summaries := make([]AdminLobby, 0, len(lobbies))
+	for _, lobby := range lobbies {
+		summary, err := s.lobbySummary(r.Context(), lobby)
+		if err != nil {
+			s.logger.Error("error loading lobby summary", "lobby", lobby, "err", err)
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) handleAdminLobby(w http.ResponseWriter, r *http.Request) {
+	lobby := mux.Vars(r)["lobby"]
+	ctx := r.Context()
+
+	limit := int64(defaultAdminHistory)
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: "Invalid limit."})
+			return
+		}
+		limit = n
+	}
+
+	members, err := s.redisClient.HGetAll(ctx, s.membersKey(lobby)).Result()
+	if err != nil {
+		s.logger.Error("error listing lobby members", "lobby", lobby, "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to load lobby."})
+		return
+	}
+	if len(members) == 0 {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "Lobby does not exist."})
+		return
+	}
+
+	summary, err := s.lobbySummary(ctx, lobby)
+	if err != nil {
+		s.logger.Error("error loading lobby summary", "lobby", lobby, "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to load lobby."})
+		return
+	}
+	detail := AdminLobbyDetail{AdminLobby: summary, Members: []AdminMember{}, History: s.recentMessages(ctx, lobby, limit)}
+	for user, instance := range members {
+		detail.Members = append(detail.Members, AdminMember{User: user, Instance: instance})
+	}
+	slices.SortFunc(detail.Members, func(a, b AdminMember) int { return strings.Compare(a.User, b.User) })
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func (s *Server) handleAdminAnnounce(w http.ResponseWriter, r *http.Request) {
+	var announcement AdminAnnouncement
+	if err := json.NewDecoder(r.Body).Decode(&announcement); err != nil || strings.TrimSpace(announcement.Message) == "" {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: "An announcement needs a message."})
+		return
+	}
+
+	lobbies := []string{announcement.Lobby}
+	if announcement.Lobby == "" {
+		var err error
+		if lobbies, err = s.listLobbies(r.Context()); err != nil {
+			s.logger.Error("error listing lobbies", "err", err)
+			writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to list lobbies."})
+			return
+		}
+	} else if exists, err := s.lobbyExists(announcement.Lobby); err != nil || !exists {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "Lobby does not exist."})
+		return
+	}
+
+	for _, lobby := range lobbies {
+		s.announce(lobby, announcement.Message)
+	}
+	s.logger.Info("admin announcement sent", "lobbies", len(lobbies), s.contentAttr(announcement.Message))
+	writeJSON(w, http.StatusOK, Response{Type: "success", Message: "Announced to " + strconv.Itoa(len(lobbies)) + " lobbies."})
+}
+
+// announce stores and broadcasts a system message with the given text
+func (s *Server) announce(lobby, text string) {
+	message := s.generateSystemMessage("announcement", lobby, "", systemColor)
+	message.Content = text
+	s.processMessageContent(&message)
+	s.storeMessage(&message)
+	s.broadcastMessage(lobby, message, "")
+}
+
+func (s *Server) handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	lobby, user := mux.Vars(r)["lobby"], mux.Vars(r)["user"]
+
+	members, err := s.lobbyMembers(lobby)
+	if err != nil {
+		s.logger.Error("error listing lobby members", "lobby", lobby, "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to load lobby."})
+		return
+	}
+	if !slices.Contains(members, user) {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "User is not in this lobby."})
+		return
+	}
+
+	frame, _ := json.Marshal(ErrorResponse{Type: "error", Message: "You were removed from the lobby by an administrator.", Code: "kicked"})
+	s.publishClose(lobby, frame, []string{user}, "removed by an administrator")
+	s.logger.Info("admin kicked user", "lobby", lobby, "user", user)
+
+	// the user's instance disconnects them, which announces the departure like any other
+	writeJSON(w, http.StatusAccepted, Response{Type: "success", Message: "User is being removed."})
+}
+
+func (s *Server) handleAdminCloseLobby(w http.ResponseWriter, r *http.Request) {
+	lobby := mux.Vars(r)["lobby"]
+
+	if exists, err := s.lobbyExists(lobby); err != nil || !exists {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "Lobby does not exist."})
+		return
+	}
+
+	// marked like an expired lobby, so it's deleted as soon as it's empty instead of lingering and nobody
+	// waiting for a seat is let in
+	ctx := r.Context()
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.lobbyMetaKey(lobby), "expired", ExpiryClosed)
+		pipe.Del(ctx, s.queueKey(lobby), s.queueSeenKey(lobby))
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error marking lobby closed", "lobby", lobby, "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to close lobby."})
+		return
+	}
+
+	frame, _ := json.Marshal(ErrorResponse{Type: "error", Message: "This lobby was closed by an administrator.", Code: "lobby_closed"})
+	s.publishClose(lobby, frame, nil, "lobby closed by an administrator")
+	s.logger.Info("admin closed lobby", "lobby", lobby)
+
+	// a lingering lobby has nobody left to disconnect, otherwise it's deleted once its last member is
+	if members, err := s.redisClient.HLen(ctx, s.membersKey(lobby)).Result(); err == nil && members == 0 {
+		s.deleteEmptyLobbies(lobby)
+	}
+	writeJSON(w, http.StatusAccepted, Response{Type: "success", Message: "Lobby is being closed."})
+}
+
+func (s *Server) handleAdminPurge(w http.ResponseWriter, r *http.Request) {
+	lobby := mux.Vars(r)["lobby"]
+	ctx := r.Context()
+
+	var purged *redis.IntCmd
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		purged = pipe.XLen(ctx, s.historyKey(lobby))
+		pipe.Del(ctx, s.historyKey(lobby))
+		if s.cfg.MigrateHistory {
+			pipe.Del(ctx, legacyHistoryKey(lobby))
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error purging lobby history", "lobby", lobby, "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to purge messages."})
+		return
+	}
+	s.logger.Info("admin purged lobby history", "lobby", lobby, "messages", purged.Val())
+	writeJSON(w, http.StatusOK, Response{Type: "success", Message: "Purged " + strconv.FormatInt(purged.Val(), 10) + " messages."})
+}
+
+// listLobbies returns every lobby with members on any instance
+func (s *Server) listLobbies(ctx context.Context) ([]string, error) {
+	var lobbies []string
+	prefix, suffix := s.cfg.KeyPrefix+"lobby:", ":members"
+	iter := s.redisClient.Scan(ctx, 0, prefix+"*"+suffix, 100).Iterator()
+	for iter.Next(ctx) {
+		lobbies = append(lobbies, strings.TrimSuffix(strings.TrimPrefix(iter.Val(), prefix), suffix))
+	}
+	slices.Sort(lobbies)
+	return lobbies, iter.Err()
+}
+
+// lobbySummary counts a lobby's members and reads its metadata
+func (s *Server) lobbySummary(ctx context.Context, lobby string) (AdminLobby, error) {
+	summary := AdminLobby{Lobby: lobby}
+
+	var members *redis.IntCmd
+	var meta *redis.MapStringStringCmd
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		members = pipe.HLen(ctx, s.membersKey(lobby))
+		meta = pipe.HGetAll(ctx, s.lobbyMetaKey(lobby))
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Members = members.Val()
+	summary.Creator = meta.Val()["creator"]
+	if created, err := strconv.ParseInt(meta.Val()["created"], 10, 64); err == nil {
+		summary.Created = time.Unix(created, 0)
+		summary.Age = time.Since(summary.Created).Round(time.Second).String()
+	}
+	if conns, ok := s.lobbyConnections.Load(lobby); ok {
+		summary.LocalConnections = len(conns.([]*LobbyUser))
+	}
+	return summary, nil
+}
+
+// recentMessages returns up to limit of the lobby's newest messages, oldest first
+func (s *Server) recentMessages(ctx context.Context, lobby string, limit int64) []Message {
+	if limit == 0 {
+		return []Message{}
+	}
+	entries, err := s.redisClient.XRevRangeN(ctx, s.historyKey(lobby), "+", "-", limit).Result()
+	if err != nil {
+		s.logger.Error("error retrieving recent messages", "lobby", lobby, "err", err)
+		return []Message{}
+	}
+	slices.Reverse(entries)
+	messages := s.decodeHistory(entries)
+	if messages == nil {
+		messages = []Message{}
+	}
+	return messages
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,141 @@
+package warpsockets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testAdminToken = "test-admin-token-0123456789"
+
+func newAdminTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.AdminToken = testAdminToken
+	})
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func adminRequest(t *testing.T, method, url, token, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// Test that the admin API needs the configured token
+func TestAdminRequiresToken(t *testing.T) {
+	_, srv := newAdminTestServer(t)
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong-token-0123456789", http.StatusUnauthorized},
+		// Redis is unreachable, so getting past auth ends in a server error
+		{testAdminToken, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if code := adminRequest(t, "GET", srv.URL+"/admin/lobbies", tt.token, ""); code != tt.want {
+			t.Errorf("token %q: got %d, want %d", tt.token, code, tt.want)
+		}
+	}
+}
+
+// Test that the admin API doesn't exist without a token configured
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	s := newTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	if code := adminRequest(t, "GET", srv.URL+"/admin/lobbies", "", ""); code != http.StatusNotFound {
+		t.Errorf("got %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// Test that announcements without a message are rejected before anything is sent
+func TestAdminAnnounceNeedsMessage(t *testing.T) {
+	_, srv := newAdminTestServer(t)
+	for _, body := range []string{``, `{}`, `{"message":"   "}`} {
+		if code := adminRequest(t, "POST", srv.URL+"/admin/announcements", testAdminToken, body); code != http.StatusBadRequest {
+			t.Errorf("body %q: got %d, want %d", body, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Test that a kicked user gets the notice, then a policy violation close frame, while others stay connected
+func TestCloseLocalKicksOnlyTarget(t *testing.T) {
+	s := newTestServer(t, nil)
+	joined := make(chan struct{}, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		user := r.URL.Query().Get("user")
+		lobbyUser := s.newLobbyUser(generateConnectionID(), conn, user, userColor(user), s.logger)
+		conns, _ := s.lobbyConnections.LoadOrStore("kick-lobby", []*LobbyUser{})
+		s.lobbyConnections.Store("kick-lobby", append(conns.([]*LobbyUser), lobbyUser))
+		joined <- struct{}{}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	dial := func(user string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?user="+user, nil)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		<-joined
+		return conn
+	}
+	target := dial("target")
+	defer target.Close()
+	other := dial("other")
+	defer other.Close()
+
+	s.closeLocal("kick-lobby", []byte(`{"type":"error","code":"kicked"}`), []string{"target"}, "removed by an administrator")
+
+	target.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, msg, err := target.ReadMessage(); err != nil || !strings.Contains(string(msg), "kicked") {
+		t.Fatalf("expected the kick notice, got %s, %v", msg, err)
+	}
+	_, _, err := target.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("expected a policy violation close frame, got %v", err)
+	}
+
+	// the other user gets nothing
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, msg, err := other.ReadMessage(); err == nil {
+		t.Errorf("other user received %s", msg)
+	} else if _, ok := err.(*websocket.CloseError); ok {
+		t.Errorf("other user was disconnected: %v", err)
+	}
+}
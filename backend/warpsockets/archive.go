@@ -0,0 +1,270 @@
+/* Durable archive: lobbies created with the archive flag have every message written to disk as well as Redis */
+package warpsockets
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// error code returned to the client when it asks for an archived lobby but archive.dir isn't set
+const CodeArchiveUnavailable = "archive_unavailable"
+
+// most messages returned by one archive API request
+const (
+	defaultArchiveLimit = 500
+	maxArchiveLimit     = 5000
+)
+
+// Describes one archive. Each archived lobby gets its own when it's created, so a lobby created later under the
+// same name doesn't add to it.
+type ArchiveInfo struct {
+	ID      string    `json:"id"`
+	Lobby   string    `json:"lobby"`
+	Created time.Time `json:"created"` // when its first message was archived
+}
+
+// MessageStore keeps lobby history outside of Redis. Redis stays the live history that members are sent,
+// archived lobbies also have every message appended to the store, which is kept after the lobby is deleted.
+type MessageStore interface {
+	// Append adds a message to archive id, starting the archive if it's the first. Messages are keyed by their
+	// sequence number, which is never 0.
+	Append(id string, message Message) error
+	// Messages returns up to limit of the archive's messages in sequence order, starting after sequence number after.
+	Messages(id string, after int64, limit int) ([]Message, error)
+	// Archives lists every archive, oldest first.
+	Archives() ([]ArchiveInfo, error)
+	Close() error
+}
+
+// WithArchive stores archived lobbies in store instead of a BoltArchive in archive.dir.
+// The store is left open on Shutdown.
+func WithArchive(store MessageStore) Option {
+	return func(s *Server) {
+		s.archive = store
+	}
+}
+
+// BoltArchive is a MessageStore kept in a bbolt database. Every archive is a bucket of messages keyed by their
+// sequence number, so a page is found with a seek rather than by reading everything before it.
+// Appends are synced to disk before returning, so archived messages survive a crash or restart.
+type BoltArchive struct {
+	db *bolt.DB
+}
+
+var (
+	archivesBucket = []byte("archives") // archive ID -> ArchiveInfo JSON
+	messagesBucket = []byte("messages") // archive ID -> bucket of sequence number -> message JSON
+)
+
+const archiveFile = "archive.db"
+
+// NewBoltArchive opens (creating if need be) the archive in dir. The database is locked while it's open, so
+// instances can't share a dir.
+func NewBoltArchive(dir string) (*BoltArchive, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, archiveFile), 0o640, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(archivesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(messagesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltArchive{db: db}, nil
+}
+
+// big-endian so keys sort in sequence order
+func archiveKey(seq int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(seq))
+}
+
+func (a *BoltArchive) Append(id string, message Message) error {
+	value, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	// appends from concurrent senders share a transaction, and its sync. The function may run more than once,
+	// which is fine as a message is put under its own sequence number
+	return a.db.Batch(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(messagesBucket)
+		archive := messages.Bucket([]byte(id))
+		if archive == nil {
+			info, err := json.Marshal(ArchiveInfo{ID: id, Lobby: message.Lobby, Created: time.Now().UTC()})
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(archivesBucket).Put([]byte(id), info); err != nil {
+				return err
+			}
+			if archive, err = messages.CreateBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return archive.Put(archiveKey(message.Seq), value)
+	})
+}
+
+func (a *BoltArchive) Messages(id string, after int64, limit int) ([]Message, error) {
+	messages := []Message{}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		archive := tx.Bucket(messagesBucket).Bucket([]byte(id))
+		if archive == nil {
+			return nil
+		}
+		cursor := archive.Cursor()
+		for k, v := cursor.Seek(archiveKey(after + 1)); k != nil && len(messages) < limit; k, v = cursor.Next() {
+			var message Message
+			if err := json.Unmarshal(v, &message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (a *BoltArchive) Archives() ([]ArchiveInfo, error) {
+	archives := []ArchiveInfo{}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(archivesBucket).ForEach(func(_, v []byte) error {
+			var info ArchiveInfo
+			if err := json.Unmarshal(v, &info); err != nil {
+				return err
+			}
+			archives = append(archives, info)
+			return nil
+		})
+	})
+	sort.Slice(archives, func(i, j int) bool {
+		if !archives[i].Created.Equal(archives[j].Created) {
+			return archives[i].Created.Before(archives[j].Created)
+		}
+		return archives[i].ID < archives[j].ID
+	})
+	return archives, err
+}
+
+func (a *BoltArchive) Close() error {
+	return a.db.Close()
+}
+
+// newArchiveID names the archive of a lobby being created with the archive flag
+func newArchiveID() string {
+	return uuid.New().String()
+}
+
+// validateArchive checks a creator asking for an archived lobby can have one
+func (s *Server) validateArchive(archive bool) *ValidationError {
+	if archive && s.archive == nil {
+		return &ValidationError{Field: "archive", Code: CodeArchiveUnavailable, Msg: "Archived lobbies aren't available on this server."}
+	}
+	return nil
+}
+
+// archiveMessages appends messages stored in Redis to the lobby's archive, if it has one. The archive is looked
+// up in the lobby's meta every time rather than remembered, since the lobby may be deleted and created again
+// under the same name by anyone on any instance.
+func (s *Server) archiveMessages(lobby string, messages ...Message) {
+	if s.archive == nil {
+		return
+	}
+	id, err := s.redisClient.HGet(context.Background(), s.lobbyMetaKey(lobby), "archive").Result()
+	if err != nil || id == "" {
+		return
+	}
+	for _, message := range messages {
+		// archives are keyed by sequence number, and 0 means Redis couldn't assign one
+		if message.Seq == 0 {
+			s.logger.Warn("skipped archiving unsequenced message", "lobby", lobby, "archive", id, "message", message.ID)
+			continue
+		}
+		if err := s.archive.Append(id, message); err != nil {
+			s.logger.Error("error archiving message", "lobby", lobby, "archive", id, "message", message.ID, "err", err)
+		}
+	}
+}
+
+// A page of an archive's messages. Next is the sequence number to ask for the following page after, 0 once there
+// are no more.
+type ArchivePage struct {
+	ID       string    `json:"id"`
+	After    int64     `json:"after"`
+	Next     int64     `json:"next,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+// GET /admin/archives lists the archives, oldest first
+func (s *Server) handleAdminListArchives(w http.ResponseWriter, r *http.Request) {
+	if s.archive == nil {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "Archiving is off, set archive.dir.", Code: CodeArchiveUnavailable})
+		return
+	}
+	archives, err := s.archive.Archives()
+	if err != nil {
+		s.logger.Error("error listing archives", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to list archives."})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]ArchiveInfo{"archives": archives})
+}
+
+// GET /admin/archives/{id}?after=&limit= pages through an archive in sequence order
+func (s *Server) handleAdminArchive(w http.ResponseWriter, r *http.Request) {
+	if s.archive == nil {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "Archiving is off, set archive.dir.", Code: CodeArchiveUnavailable})
+		return
+	}
+	id := mux.Vars(r)["id"]
+	after, limit := int64(0), defaultArchiveLimit
+	var err error
+	if v := r.URL.Query().Get("after"); v != "" {
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil || after < 0 {
+			writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: "after must be a sequence number."})
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: "limit must be a positive number."})
+			return
+		}
+		limit = min(limit, maxArchiveLimit)
+	}
+
+	messages, err := s.archive.Messages(id, after, limit)
+	if err != nil {
+		s.logger.Error("error reading archive", "archive", id, "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to read the archive."})
+		return
+	}
+	if after == 0 && len(messages) == 0 {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "No such archive."})
+		return
+	}
+	page := ArchivePage{ID: id, After: after, Messages: messages}
+	if len(messages) == limit {
+		page.Next = messages[len(messages)-1].Seq
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,197 @@
+package warpsockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func archiveTestMessage(lobby string, seq int64) Message {
+	return Message{ID: fmt.Sprint(seq), Lobby: lobby, Seq: seq, User: "grant", Content: fmt.Sprint("message ", seq),
+		Color: "#ff0000", Time: time.Unix(1700000000+seq, 0).UTC(), FormattedTime: "3:04 PM"}
+}
+
+// Test that archived messages are read back after the archive is reopened, as after a restart, and that a lobby
+// created again under the same name is archived apart
+func TestBoltArchiveSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewBoltArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []Message
+	for seq := int64(1); seq <= 3; seq++ {
+		message := archiveTestMessage("retro games", seq)
+		want = append(want, message)
+		if err := archive.Append("first", message); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+	archive.Append("second", archiveTestMessage("retro games", 1))
+	if err := archive.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	archive, err = NewBoltArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	got, err := archive.Messages("first", 0, 10)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	if got, _ := archive.Messages("second", 0, 10); len(got) != 1 {
+		t.Errorf("got %+v for the lobby created again", got)
+	}
+
+	archives, err := archive.Archives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 2 || archives[0].ID != "first" || archives[1].ID != "second" || archives[1].Lobby != "retro games" {
+		t.Errorf("got archives %+v", archives)
+	}
+}
+
+// Test that archives are paged by sequence number, whatever order messages were appended in
+func TestBoltArchivePaging(t *testing.T) {
+	archive, err := NewBoltArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	for _, seq := range []int64{2, 1, 5, 3, 4, 300} {
+		archive.Append("retro", archiveTestMessage("retro", seq))
+	}
+
+	got, err := archive.Messages("retro", 3, 2)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Seq != 4 || got[1].Seq != 5 {
+		t.Errorf("got %+v", got)
+	}
+	got, _ = archive.Messages("retro", 5, 10)
+	if len(got) != 1 || got[0].Seq != 300 {
+		t.Errorf("got %+v", got)
+	}
+	if got, _ := archive.Messages("nowhere", 0, 10); len(got) != 0 {
+		t.Errorf("got %+v for an archive that doesn't exist", got)
+	}
+}
+
+// Test that creators can only ask for archived lobbies when archive.dir is set
+func TestValidateArchive(t *testing.T) {
+	s := newTestServer(t, nil)
+	if verr := s.validateArchive(true); verr == nil || verr.Code != CodeArchiveUnavailable {
+		t.Errorf("got %v want %s", verr, CodeArchiveUnavailable)
+	}
+	if verr := s.validateArchive(false); verr != nil {
+		t.Errorf("unexpected error %v", verr)
+	}
+
+	s = newTestServer(t, func(cfg *Config) {
+		cfg.ArchiveDir = filepath.Join(t.TempDir(), "archive")
+	})
+	if verr := s.validateArchive(true); verr != nil {
+		t.Errorf("unexpected error %v", verr)
+	}
+}
+
+// Test that the archive API pages through an archive
+func TestAdminArchive(t *testing.T) {
+	archive, err := NewBoltArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	for seq := int64(1); seq <= 3; seq++ {
+		archive.Append("a1", archiveTestMessage("retro", seq))
+	}
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.AdminToken = testAdminToken
+	}, WithArchive(archive))
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(path string) (int, ArchivePage) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var page ArchivePage
+		json.NewDecoder(resp.Body).Decode(&page)
+		return resp.StatusCode, page
+	}
+
+	status, page := get("/admin/archives/a1?limit=2")
+	if status != http.StatusOK || len(page.Messages) != 2 || page.Next != 2 {
+		t.Errorf("first page: got %d %+v", status, page)
+	}
+	status, page = get("/admin/archives/a1?after=2&limit=2")
+	if status != http.StatusOK || len(page.Messages) != 1 || page.Messages[0].Seq != 3 || page.Next != 0 {
+		t.Errorf("last page: got %d %+v", status, page)
+	}
+	for path, want := range map[string]int{
+		"/admin/archives":             http.StatusOK,
+		"/admin/archives/nowhere":     http.StatusNotFound,
+		"/admin/archives/a1?limit=0":  http.StatusBadRequest,
+		"/admin/archives/a1?after=-1": http.StatusBadRequest,
+	} {
+		if status, _ := get(path); status != want {
+			t.Errorf("%s: got %d want %d", path, status, want)
+		}
+	}
+}
+
+// Test that which archive a lobby's messages go to is read from its meta for every message rather than remembered,
+// so a lobby deleted and created again under the same name doesn't inherit the old decision
+func TestArchiveLooksUpLobbyMeta(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.ArchiveDir = t.TempDir()
+	})
+	hook := &recordingHook{}
+	s.redisClient.AddHook(hook)
+
+	for seq := int64(1); seq <= 2; seq++ {
+		s.archiveMessages("retro", archiveTestMessage("retro", seq))
+	}
+	lookups := 0
+	for _, cmd := range hook.cmds {
+		if reflect.DeepEqual(cmd, []interface{}{"hget", s.lobbyMetaKey("retro"), "archive"}) {
+			lookups++
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("got %d lookups of the lobby's archive, want one per message", lookups)
+	}
+}
+
+// Test that a message Redis couldn't number isn't archived, where it would overwrite the last one that also failed
+func TestArchiveSkipsUnsequencedMessages(t *testing.T) {
+	s, mr := newRedisTestServer(t, func(cfg *Config) {
+		cfg.ArchiveDir = t.TempDir()
+	})
+	mr.HSet(s.lobbyMetaKey("retro"), "archive", "retro-archive")
+
+	s.archiveMessages("retro", archiveTestMessage("retro", 0), archiveTestMessage("retro", 0), archiveTestMessage("retro", 1))
+	got, err := s.archive.Messages("retro-archive", 0, 10)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Seq != 1 {
+		t.Errorf("got %+v, want only the sequenced message", got)
+	}
+}
@@ -0,0 +1,309 @@
+/* Lobby capacity limits, and the waiting queue that admits users to full lobbies as seats free up */
+package warpsockets
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// error codes returned to the client when a join is refused for capacity
+const (
+	CodeLobbyFull       = "lobby_full"
+	CodeQueueFull       = "queue_full"
+	CodeServerFull      = "server_full"
+	CodeCapacityInvalid = "capacity_invalid"
+)
+
+// lobby:<name>:queue is a sorted set of waiting connection IDs, scored by when they started waiting
+func (s *Server) queueKey(lobby string) string {
+	return s.lobbyKey(lobby, "queue")
+}
+
+// lobby:<name>:queue-seen is a hash of waiting connection ID -> unix time it last checked its place.
+// waiters that stop checking (their instance died) are dropped from the queue by the others.
+func (s *Server) queueSeenKey(lobby string) string {
+	return s.lobbyKey(lobby, "queue-seen")
+}
+
+// Sent to a client waiting for a seat whenever its place in the queue changes. Position 0 means it got in.
+type QueuePosition struct {
+	Type     string `json:"type"`     // always "queue"
+	Position int64  `json:"position"` // 1 is next in line
+}
+
+// The lobby's capacity is the creator's choice (meta "capacity") capped at limits.lobby_members.
+// Shared by both scripts below, which expect KEYS[1] members, KEYS[2] meta and ARGV[3] the server limit.
+const capacityLua = `
+local capacity = tonumber(ARGV[3])
+local chosen = tonumber(redis.call('HGET', KEYS[2], 'capacity') or '0') or 0
+if chosen > 0 and chosen < capacity then capacity = chosen end
+`
+
+// claimSeatScript adds ARGV[1] to the lobby's members (as held by instance ARGV[2]) if there's a free seat and
+// nobody is queued for one. The name is claimed along with the seat: someone else already holding it gets -1,
+// unless ARGV[4] is "1" (the user resumed their session) and the seat is being held for them across a restart.
+// Returns 1 if the user is a member afterwards, 0 if the lobby is full.
+var claimSeatScript = redis.NewScript(capacityLua + `
+local owner = redis.call('HGET', KEYS[1], ARGV[1])
+if owner then
+	if ARGV[4] ~= '1' or owner ~= ARGV[5] then return -1 end
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+if redis.call('ZCARD', KEYS[3]) > 0 or redis.call('HLEN', KEYS[1]) >= capacity then return 0 end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// admitScript checks on waiting connection ARGV[4] at unix time ARGV[5], first dropping waiters not seen since
+// ARGV[6]. Seats go to the front of the queue: the waiter is made a member if there's a seat for everyone ahead
+// of it. Returns 0 once admitted, its place in line while waiting, -1 if it isn't queued, or -2 (taking it out of
+// the queue) if someone joined under its name meanwhile.
+var admitScript = redis.NewScript(capacityLua + `
+if not redis.call('ZSCORE', KEYS[3], ARGV[4]) then return -1 end
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	redis.call('ZREM', KEYS[3], ARGV[4])
+	redis.call('HDEL', KEYS[4], ARGV[4])
+	return -2
+end
+redis.call('HSET', KEYS[4], ARGV[4], ARGV[5])
+for _, id in ipairs(redis.call('ZRANGE', KEYS[3], 0, -1)) do
+	if tonumber(redis.call('HGET', KEYS[4], id) or '0') < tonumber(ARGV[6]) then
+		redis.call('ZREM', KEYS[3], id)
+		redis.call('HDEL', KEYS[4], id)
+	end
+end
+local free = capacity - redis.call('HLEN', KEYS[1])
+if free < 0 then free = 0 end
+local rank = redis.call('ZRANK', KEYS[3], ARGV[4])
+if rank < free then
+	redis.call('ZREM', KEYS[3], ARGV[4])
+	redis.call('HDEL', KEYS[4], ARGV[4])
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	return 0
+end
+return rank - free + 1
+`)
+
+// validateCapacity checks the member limit a creator asked for, 0 meaning limits.lobby_members
+func (s *Server) validateCapacity(capacity int) *ValidationError {
+	if capacity < 0 || capacity > s.cfg.LobbyMaxMembers {
+		return &ValidationError{Field: "capacity", Code: CodeCapacityInvalid,
+			Msg: fmt.Sprintf("Lobby capacity must be between 1 and %d, or 0 for the server's limit.", s.cfg.LobbyMaxMembers)}
+	}
+	return nil
+}
+
+// instanceFull reports whether this instance already holds limits.connections lobby members
+func (s *Server) instanceFull() bool {
+	if s.cfg.MaxConnections <= 0 {
+		return false
+	}
+	connections, _ := s.localCounts()
+	return connections >= s.cfg.MaxConnections
+}
+
+// lobbyFull reports whether the lobby has no seat left for a newcomer, counting anyone already waiting for one
+func (s *Server) lobbyFull(ctx context.Context, lobby string) (bool, error) {
+	var members, queued *redis.IntCmd
+	var chosen *redis.StringCmd
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		members = pipe.HLen(ctx, s.membersKey(lobby))
+		queued = pipe.ZCard(ctx, s.queueKey(lobby))
+		chosen = pipe.HGet(ctx, s.lobbyMetaKey(lobby), "capacity")
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	return queued.Val() > 0 || members.Val() >= s.lobbyCapacity(chosen.Val()), nil
+}
+
+// lobbyCapacity applies limits.lobby_members to the capacity stored in a lobby's meta, like capacityLua does
+func (s *Server) lobbyCapacity(chosen string) int64 {
+	capacity := int64(s.cfg.LobbyMaxMembers)
+	if n, err := strconv.ParseInt(chosen, 10, 64); err == nil && n > 0 && n < capacity {
+		capacity = n
+	}
+	return capacity
+}
+
+// outcomes of claimSeat
+const (
+	seatClaimed = iota
+	seatFull
+	seatNameTaken
+)
+
+// claimSeat makes user a member of the lobby if it has room and nobody in it goes by that name. Users resuming
+// after a restart take back the seat held for them. Redis errors let the user in rather than lock everyone out.
+func (s *Server) claimSeat(ctx context.Context, lobby, user string, resumed bool) int {
+	keys := []string{s.membersKey(lobby), s.lobbyMetaKey(lobby), s.queueKey(lobby)}
+	claimed, err := claimSeatScript.Run(ctx, s.redisClient, keys, user, s.instanceID, s.cfg.LobbyMaxMembers,
+		resumed, restartOwner).Int()
+	switch {
+	case err != nil:
+		s.logger.Error("error claiming lobby seat", "lobby", lobby, "user", user, "err", err)
+		return seatClaimed
+	case claimed < 0:
+		return seatNameTaken
+	case claimed == 0:
+		return seatFull
+	}
+	return seatClaimed
+}
+
+// enqueueWaiter puts a connection at the back of the lobby's queue. Returns false if the queue is full.
+func (s *Server) enqueueWaiter(ctx context.Context, lobby, connID string) (bool, error) {
+	queued, err := s.redisClient.ZCard(ctx, s.queueKey(lobby)).Result()
+	if err != nil {
+		return false, err
+	}
+	if queued >= int64(s.cfg.QueueMaxLen) {
+		return false, nil
+	}
+	now := time.Now()
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddNX(ctx, s.queueKey(lobby), redis.Z{Score: float64(now.UnixMilli()), Member: connID})
+		pipe.HSet(ctx, s.queueSeenKey(lobby), connID, now.Unix())
+		return nil
+	})
+	return err == nil, err
+}
+
+func (s *Server) leaveQueue(lobby, connID string) {
+	ctx := context.Background()
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, s.queueKey(lobby), connID)
+		pipe.HDel(ctx, s.queueSeenKey(lobby), connID)
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error leaving lobby queue", "lobby", lobby, "conn", connID, "err", err)
+	}
+}
+
+// admit checks on a waiting connection, see admitScript
+func (s *Server) admit(ctx context.Context, lobby, user, connID string) (int64, error) {
+	now := time.Now()
+	keys := []string{s.membersKey(lobby), s.lobbyMetaKey(lobby), s.queueKey(lobby), s.queueSeenKey(lobby)}
+	return admitScript.Run(ctx, s.redisClient, keys, user, s.instanceID, s.cfg.LobbyMaxMembers,
+		connID, now.Unix(), now.Add(-s.cfg.LeaseTTL).Unix()).Int64()
+}
+
+// hasWaiters reports whether anyone still checking on their place is queued for the lobby
+func (s *Server) hasWaiters(ctx context.Context, lobby string) bool {
+	seen, err := s.redisClient.HVals(ctx, s.queueSeenKey(lobby)).Result()
+	if err != nil {
+		return false
+	}
+	staleBefore := time.Now().Add(-s.cfg.LeaseTTL).Unix()
+	for _, at := range seen {
+		if n, _ := strconv.ParseInt(at, 10, 64); n >= staleBefore {
+			return true
+		}
+	}
+	return false
+}
+
+// One read from a connection, passed from the reader goroutine started by waitForSeat.
+type socketRead struct {
+	msg []byte
+	err error
+}
+
+// waitForSeat keeps a user joining a full lobby in its queue, sending them their place whenever it changes,
+// until they're admitted. Because the user can leave while waiting, the connection is read from a goroutine
+// from here on: reads delivers everything it reads, for the read loop to take over once admitted.
+// Returns false if the user left, the queue was full or the server is shutting down.
+func (s *Server) waitForSeat(lobbyUser *LobbyUser, lobby string) (reads <-chan socketRead, admitted bool) {
+	ctx := context.Background()
+	logger := lobbyUser.logger
+
+	if ok, err := s.enqueueWaiter(ctx, lobby, lobbyUser.ID); err != nil || !ok {
+		if err != nil {
+			logger.Error("error joining lobby queue", "err", err)
+		}
+		logger.Info("refused join to full lobby, queue is full")
+		lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Lobby is full and so is its queue, try again later.", Code: CodeQueueFull})
+		return nil, false
+	}
+	logger.Info("lobby is full, user queued")
+
+	readCh := make(chan socketRead)
+	// stops the reader if the user never gets in
+	quit := make(chan struct{})
+	defer func() {
+		if !admitted {
+			close(quit)
+		}
+	}()
+	go func() {
+		defer close(readCh)
+		for {
+			_, msg, err := lobbyUser.Conn.ReadMessage()
+			select {
+			case readCh <- socketRead{msg, err}:
+			case <-quit:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.cfg.QueuePoll)
+	defer ticker.Stop()
+	var last int64
+	for {
+		position, err := s.admit(ctx, lobby, lobbyUser.User, lobbyUser.ID)
+		switch {
+		case err != nil:
+			logger.Warn("error checking place in lobby queue", "err", err)
+		case position == 0:
+			logger.Info("admitted from lobby queue")
+			lobbyUser.enqueueJSON(QueuePosition{Type: "queue", Position: 0})
+			return readCh, true
+		case position == -2:
+			logger.Info("name taken while queued")
+			lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Someone in the lobby already goes by that name.", Code: CodeNameTaken})
+			return nil, false
+		case position < 0:
+			// the lobby expired, or it was deleted out from under the queue
+			if s.lobbyClosed(ctx, lobby) {
+				logger.Info("lobby closed while queued")
+				lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "This lobby was closed.", Code: "lobby_closed"})
+				return nil, false
+			}
+			// dropped for missing checks (Redis was unreachable for a while), take a new place in line
+			if _, err := s.enqueueWaiter(ctx, lobby, lobbyUser.ID); err != nil {
+				logger.Warn("error rejoining lobby queue", "err", err)
+			}
+		case position != last:
+			last = position
+			lobbyUser.enqueueJSON(QueuePosition{Type: "queue", Position: position})
+		}
+
+		select {
+		case read := <-readCh:
+			// clients don't send anything while queued, anything they do is dropped
+			if read.err != nil {
+				logger.Info("user left the lobby queue", "err", read.err)
+				s.leaveQueue(lobby, lobbyUser.ID)
+				return nil, false
+			}
+		case <-ticker.C:
+			if s.draining.Load() {
+				s.leaveQueue(lobby, lobbyUser.ID)
+				lobbyUser.disconnect(websocket.CloseGoingAway, "server shutting down")
+				return nil, false
+			}
+		}
+	}
+}
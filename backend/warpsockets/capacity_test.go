@@ -0,0 +1,188 @@
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Test that creators can pick any capacity up to limits.lobby_members, with 0 meaning the limit itself
+func TestValidateCapacity(t *testing.T) {
+	s := newTestServer(t, nil)
+	max := s.cfg.LobbyMaxMembers
+
+	for capacity, valid := range map[int]bool{0: true, 1: true, max: true, max + 1: false, -1: false} {
+		if verr := s.validateCapacity(capacity); (verr == nil) != valid {
+			t.Errorf("capacity %d: got %v, want valid %v", capacity, verr, valid)
+		}
+	}
+	if got := s.lobbyCapacity("5"); got != 5 {
+		t.Errorf("got capacity %d, want 5", got)
+	}
+	for _, chosen := range []string{"", "0", "100000"} {
+		if got := s.lobbyCapacity(chosen); got != int64(max) {
+			t.Errorf("%q: got capacity %d, want %d", chosen, got, max)
+		}
+	}
+}
+
+// Test that lobby checks asking for too many seats are refused before touching Redis
+func TestCheckLobbyRejectsCapacity(t *testing.T) {
+	s := newTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/check-lobby", "application/json", strings.NewReader(`{"action":"create","user":"grant","lobby":"big","capacity":100000}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body Response
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusBadRequest || body.Code != CodeCapacityInvalid {
+		t.Errorf("got %d %+v, want %d with code %s", resp.StatusCode, body, http.StatusBadRequest, CodeCapacityInvalid)
+	}
+}
+
+// Test that an instance at limits.connections turns new members away with a typed error
+func TestHandshakeRefusedWhenInstanceFull(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.MaxConnections = 1
+	})
+	s.lobbyConnections.Store("busy", []*LobbyUser{{User: "already-here"}})
+	if !s.instanceFull() {
+		t.Fatalf("expected the instance to be full")
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(LobbyInfo{Lobby: "other", User: "grant", Action: "join"}); err != nil {
+		t.Fatalf("failed to send lobby info: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply ErrorResponse
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	if reply.Code != CodeServerFull {
+		t.Errorf("got %+v, want code %s", reply, CodeServerFull)
+	}
+}
+
+// Test claimSeatScript: seats go until the lobby's chosen capacity, names are unique, and a seat held across a
+// restart goes back to its user only when they resume
+func TestClaimSeatScript(t *testing.T) {
+	s, mr := newRedisTestServer(t, nil)
+	ctx := context.Background()
+	mr.HSet(s.lobbyMetaKey("small"), "capacity", "2")
+
+	for user, want := range map[string]int{"grant": seatClaimed, "lee": seatClaimed} {
+		if got := s.claimSeat(ctx, "small", user, false); got != want {
+			t.Errorf("%s: got outcome %d, want %d", user, got, want)
+		}
+	}
+	if got := s.claimSeat(ctx, "small", "sherman", false); got != seatFull {
+		t.Errorf("third user: got outcome %d, want seatFull", got)
+	}
+	if got := s.claimSeat(ctx, "other", "grant", false); got != seatClaimed {
+		t.Errorf("same name in another lobby: got outcome %d, want seatClaimed", got)
+	}
+
+	mr.HSet(s.membersKey("open"), "grant", "another-instance")
+	if got := s.claimSeat(ctx, "open", "grant", false); got != seatNameTaken {
+		t.Errorf("name held by another instance: got outcome %d, want seatNameTaken", got)
+	}
+	if got := s.claimSeat(ctx, "open", "grant", true); got != seatNameTaken {
+		t.Errorf("resuming onto a live seat: got outcome %d, want seatNameTaken", got)
+	}
+
+	mr.HSet(s.membersKey("open"), "lee", restartOwner)
+	if got := s.claimSeat(ctx, "open", "lee", false); got != seatNameTaken {
+		t.Errorf("held seat without resuming: got outcome %d, want seatNameTaken", got)
+	}
+	if got := s.claimSeat(ctx, "open", "lee", true); got != seatClaimed {
+		t.Errorf("resuming onto a held seat: got outcome %d, want seatClaimed", got)
+	}
+	if owner := mr.HGet(s.membersKey("open"), "lee"); owner != s.instanceID {
+		t.Errorf("resumed seat held by %q, want %q", owner, s.instanceID)
+	}
+
+	// nobody jumps the queue, even with a seat free
+	mr.ZAdd(s.queueKey("open"), 1, "waiting")
+	if got := s.claimSeat(ctx, "open", "sherman", false); got != seatFull {
+		t.Errorf("with a queue: got outcome %d, want seatFull", got)
+	}
+}
+
+// Test admitScript: waiters get in in order as seats free up, stale waiters are dropped, and a waiter whose name
+// was taken meanwhile is taken out of the queue
+func TestAdmitScript(t *testing.T) {
+	s, mr := newRedisTestServer(t, nil)
+	ctx := context.Background()
+	mr.HSet(s.lobbyMetaKey("small"), "capacity", "1")
+	if got := s.claimSeat(ctx, "small", "grant", false); got != seatClaimed {
+		t.Fatalf("got outcome %d, want seatClaimed", got)
+	}
+
+	if position, err := s.admit(ctx, "small", "lee", "conn-lee"); err != nil || position != -1 {
+		t.Errorf("not queued: got %d, %v, want -1", position, err)
+	}
+	for _, connID := range []string{"conn-lee", "conn-sherman"} {
+		if ok, err := s.enqueueWaiter(ctx, "small", connID); err != nil || !ok {
+			t.Fatalf("failed to queue %s: %v", connID, err)
+		}
+		time.Sleep(2 * time.Millisecond) // queue order is by millisecond
+	}
+	if position, err := s.admit(ctx, "small", "lee", "conn-lee"); err != nil || position != 1 {
+		t.Errorf("first in line: got %d, %v, want 1", position, err)
+	}
+	if position, err := s.admit(ctx, "small", "sherman", "conn-sherman"); err != nil || position != 2 {
+		t.Errorf("second in line: got %d, %v, want 2", position, err)
+	}
+
+	// a seat frees up, the second waiter can't take it ahead of the first
+	mr.HDel(s.membersKey("small"), "grant")
+	if position, err := s.admit(ctx, "small", "sherman", "conn-sherman"); err != nil || position != 1 {
+		t.Errorf("second in line with one seat: got %d, %v, want 1", position, err)
+	}
+	if position, err := s.admit(ctx, "small", "lee", "conn-lee"); err != nil || position != 0 {
+		t.Errorf("first in line with one seat: got %d, %v, want 0", position, err)
+	}
+	if owner := mr.HGet(s.membersKey("small"), "lee"); owner != s.instanceID {
+		t.Errorf("admitted member held by %q, want %q", owner, s.instanceID)
+	}
+
+	// a waiter that stopped checking in loses its place
+	if ok, err := s.enqueueWaiter(ctx, "small", "conn-gone"); err != nil || !ok {
+		t.Fatalf("failed to queue: %v", err)
+	}
+	mr.HSet(s.queueSeenKey("small"), "conn-sherman", "1")
+	if position, err := s.admit(ctx, "small", "hood", "conn-gone"); err != nil || position != 1 {
+		t.Errorf("behind a stale waiter: got %d, %v, want 1", position, err)
+	}
+	if position, err := s.admit(ctx, "small", "sherman", "conn-sherman"); err != nil || position != -1 {
+		t.Errorf("stale waiter: got %d, %v, want -1", position, err)
+	}
+
+	// someone joined under a waiter's name
+	mr.HSet(s.membersKey("small"), "hood", "another-instance")
+	if position, err := s.admit(ctx, "small", "hood", "conn-gone"); err != nil || position != -2 {
+		t.Errorf("name taken: got %d, %v, want -2", position, err)
+	}
+	if members, _ := mr.ZMembers(s.queueKey("small")); len(members) != 0 {
+		t.Errorf("queue still holds %v", members)
+	}
+}
@@ -0,0 +1,128 @@
+package warpsockets
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Response struct for JSON responses
+type Response struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+// check if the lobby exists in the database
+// respond to the HTTP request accordingly based on the requests `action` property
+func (s *Server) checkLobbyExist(w http.ResponseWriter, r *http.Request) {
+	// parse request body to extract action, user, and lobby (and what to create the lobby with)
+	var requestData LobbyInfo
+	// every line about this request can be matched up by its ID
+	logger := s.logger.With("request", generateConnectionID(), "remote", r.RemoteAddr)
+
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		logger.Debug("lobby check with invalid body", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Type: "error", Message: "Invalid request body."})
+		return
+	}
+
+	// reject names the frontend would never send (or that impersonate the server) before looking anything up
+	if verr := s.validateLobbyRequest(&requestData); verr != nil {
+		logger.Info("rejected lobby check", "field", verr.Field, "code", verr.Code, "err", verr)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Type: "error", Message: verr.Msg, Code: verr.Code})
+		return
+	}
+
+	logger = logger.With("lobby", requestData.Lobby, "user", requestData.User)
+	// only a token issued for this very lobby and user counts as resuming a session after a restart
+	resuming := s.peekResumeToken(requestData.Resume, requestData.Lobby, requestData.User)
+
+	// during maintenance only users resuming a session get through
+	if maintenance := s.Maintenance(); maintenance.Enabled && !resuming {
+		logger.Info("refused lobby check during maintenance")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Type: "error", Message: maintenance.Message, Code: "maintenance"})
+		return
+	}
+
+	// this instance is at limits.connections, though another behind the same load balancer may not be
+	if !resuming && s.instanceFull() {
+		logger.Warn("refused lobby check, instance is at limits.connections")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Type: "error", Message: "Server is full, try again later.", Code: CodeServerFull})
+		return
+	}
+
+	// lobbies can be hosted by any server instance, so existence and membership come from the Redis registry
+	exists, err := s.lobbyExists(requestData.Lobby)
+	if err != nil {
+		logger.Error("error checking lobby in Redis", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Type: "error", Message: "Unable to check lobby, try again."})
+		return
+	}
+
+	// action switch case to determine whether the lobby's existence matters or not for allowing WebSocket upgrade
+	switch requestData.Action {
+	case "create":
+		if exists {
+			logger.Info("tried to create a lobby that already exists")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{Type: "error", Message: "Lobby already exists."})
+			return
+		}
+		// if lobby doesn't exist, do nothing so that the OK response can be sent to client.
+	case "join":
+		if !exists {
+			logger.Info("tried to join a lobby that doesn't exist")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(Response{Type: "error", Message: "Lobby does not exist."})
+			return
+		}
+		// if lobby exists, make sure there isn't username conflict before the OK response is sent to client.
+		members, err := s.lobbyMembers(requestData.Lobby)
+		if err != nil {
+			logger.Error("error listing lobby members", "err", err)
+		}
+		for _, member := range members {
+			// a user resuming after a server restart is still listed as a member until they reconnect
+			if sameName(member, requestData.User) && !resuming {
+				logger.Info("user already joined this lobby")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(Response{Type: "error", Message: "User already in lobby."})
+				return
+			}
+		}
+		// users resuming after a restart kept their seat
+		if !resuming {
+			full, err := s.lobbyFull(r.Context(), requestData.Lobby)
+			if err != nil {
+				logger.Error("error checking lobby capacity", "err", err)
+			}
+			if full && !s.cfg.WaitingQueue {
+				logger.Info("tried to join a full lobby")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(Response{Type: "error", Message: "Lobby is full.", Code: CodeLobbyFull})
+				return
+			}
+			if full {
+				// the handshake puts them in line (see waitForSeat)
+				logger.Debug("lobby check passed, user will be queued")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(Response{Type: "success", Message: "Lobby is full, you'll wait in line for a seat.", Code: "queued"})
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Type: "error", Message: "Invalid action."})
+		return
+	}
+
+	// WebSocket upgrade is approved, respond OK
+	logger.Debug("lobby check passed", "action", requestData.Action)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Type: "success", Message: "Lobby check successful"})
+}
@@ -0,0 +1,61 @@
+/* Server-assigned user colors, derived the same way as the frontend's minidenticon avatars */
+package warpsockets
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// minidenticons picks one of 9 evenly spaced hues. the frontend renders avatars with 90% saturation and
+// 55% lightness (see generateAvatarAndColor in frontend/src/components/utils.js)
+const (
+	identiconColors     = 9
+	identiconSaturation = 90
+	identiconLightness  = 55
+)
+
+// color used for messages generated by the server
+const systemColor = "#b5b3b0"
+
+// userColor deterministically derives a user's color from their username.
+// Matches the fill color of the minidenticon generated for the same username, converted to hex.
+func userColor(username string) string {
+	hue := float64(identiconHash(username)%identiconColors) * (360 / identiconColors)
+	return hslToHex(hue, identiconSaturation/100.0, identiconLightness/100.0)
+}
+
+// port of minidenticons' simpleHash. JS bitwise operators work on int32, and split('') iterates UTF-16 code units
+func identiconHash(seed string) uint32 {
+	hash := int32(5)
+	for _, unit := range utf16.Encode([]rune(seed)) {
+		hash = (hash ^ int32(unit)) * -5
+	}
+	return uint32(hash) >> 2
+}
+
+// hslToHex converts a hue in degrees and saturation/lightness in [0, 1] to "#rrggbb"
+func hslToHex(h, s, l float64) string {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	toByte := func(v float64) uint8 { return uint8(math.Round((v + m) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", toByte(r), toByte(g), toByte(b))
+}
@@ -0,0 +1,27 @@
+package warpsockets
+
+import "testing"
+
+// Test that server colors line up with the minidenticon hues the frontend generates
+// (expected hashes taken from minidenticons' simpleHash run in node)
+func TestUserColor(t *testing.T) {
+	tests := []struct {
+		user  string
+		hash  uint32
+		color string
+	}{
+		{"grant", 1073673985, "#25f4af"}, // hue 160
+		{"alice", 1073676157, "#af25f4"}, // hue 280
+		{"bob", 1073737899, "#f42525"},   // hue 0
+		{"😀x", 1071733824, "#f42525"},    // surrogate pairs hash as two code units
+	}
+
+	for _, tt := range tests {
+		if got := identiconHash(tt.user); got != tt.hash {
+			t.Errorf("identiconHash(%q) = %d, want %d", tt.user, got, tt.hash)
+		}
+		if got := userColor(tt.user); got != tt.color {
+			t.Errorf("userColor(%q) = %s, want %s", tt.user, got, tt.color)
+		}
+	}
+}
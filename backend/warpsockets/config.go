@@ -0,0 +1,351 @@
+/* Typed server configuration, loaded from defaults, a config file, environment variables and flags (in that order) */
+package warpsockets
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Every setting the server reads. Each field is described by its tags:
+//   - key: name in the config file ("section.name") and of the command-line flag (-section.name)
+//   - env: environment variable overriding it
+//   - default: value used when nothing else sets it
+//   - secret: redacted when the config is printed
+//
+// See backend/warpsockets.example.toml for a documented config file.
+type Config struct {
+	// HTTP server
+	Addr           string        `key:"server.addr" env:"ADDR" default:":8085" help:"address the HTTP server listens on"`
+	StaticDir      string        `key:"server.static_dir" env:"STATIC_DIR" default:"" help:"directory of the built frontend, served at / when set"`
+	AllowedOrigins []string      `key:"server.allowed_origins" env:"ALLOWED_ORIGINS" default:"https://warpsockets.grantschussler.dev,http://localhost:3000,http://localhost:8085" help:"origins allowed to use the API and open sockets (supports https://*.example.com and *)"`
+	Metrics        bool          `key:"server.metrics" env:"METRICS" default:"true" help:"serve Prometheus metrics on /metrics"`
+	DrainTimeout   time.Duration `key:"server.drain_timeout" env:"DRAIN_TIMEOUT" default:"10s" help:"upper bound on graceful shutdown"`
+
+	// WebSocket connections
+	ReadBufferSize    int           `key:"websocket.read_buffer_size" env:"WS_READ_BUFFER_SIZE" default:"1024" help:"WebSocket read buffer size in bytes"`
+	WriteBufferSize   int           `key:"websocket.write_buffer_size" env:"WS_WRITE_BUFFER_SIZE" default:"1024" help:"WebSocket write buffer size in bytes"`
+	UpgradeRetries    int           `key:"websocket.upgrade_retries" env:"WS_UPGRADE_RETRIES" default:"5" help:"times a failed WebSocket upgrade is retried"`
+	UpgradeRetryDelay time.Duration `key:"websocket.upgrade_retry_delay" env:"WS_UPGRADE_RETRY_DELAY" default:"5s" help:"wait between upgrade retries"`
+	WriteWait         time.Duration `key:"websocket.write_wait" env:"WS_WRITE_WAIT" default:"10s" help:"how long a single frame may take to write"`
+	SendQueueSlack    int           `key:"websocket.send_queue_slack" env:"WS_SEND_QUEUE_SLACK" default:"256" help:"frames a client may fall behind (on top of a history replay) before it is dropped"`
+
+	// admin API
+	AdminToken string `key:"admin.token" env:"ADMIN_TOKEN" default:"" secret:"true" help:"bearer token for the /admin API, which is off while empty"`
+
+	// maintenance mode
+	MaintenanceMessage string `key:"maintenance.message" env:"MAINTENANCE_MESSAGE" default:"Warpsockets is about to go down for maintenance, new lobbies can't be joined right now." help:"shown to users turned away during maintenance (and the banner sent on SIGUSR1)"`
+
+	// capacity
+	LobbyMaxMembers int           `key:"limits.lobby_members" env:"LOBBY_MAX_MEMBERS" default:"50" help:"most members a lobby may hold, creators can pick a lower limit"`
+	MaxConnections  int           `key:"limits.connections" env:"MAX_CONNECTIONS" default:"0" help:"most lobby members this instance holds across all lobbies, 0 for no limit"`
+	WaitingQueue    bool          `key:"limits.queue" env:"LOBBY_QUEUE" default:"false" help:"queue joins to full lobbies and admit them as seats free up, instead of refusing them"`
+	QueueMaxLen     int           `key:"limits.queue_length" env:"LOBBY_QUEUE_LENGTH" default:"100" help:"most users waiting for one lobby"`
+	QueuePoll       time.Duration `key:"limits.queue_poll" env:"LOBBY_QUEUE_POLL" default:"1s" help:"how often waiting users check for a free seat"`
+
+	// lobby expiry
+	IdleTimeout   time.Duration `key:"expiry.idle" env:"LOBBY_IDLE_TIMEOUT" default:"0s" help:"close lobbies without a message for this long, 0 to keep them"`
+	MaxLifetime   time.Duration `key:"expiry.lifetime" env:"LOBBY_MAX_LIFETIME" default:"0s" help:"close lobbies this long after they were created, 0 to keep them"`
+	ExpiryWarning time.Duration `key:"expiry.warning" env:"LOBBY_EXPIRY_WARNING" default:"1m" help:"how long before expiry members are warned"`
+	Linger        time.Duration `key:"expiry.linger" env:"LOBBY_LINGER" default:"30s" help:"how long an empty lobby and its history are kept for someone to rejoin, 0 to delete it right away"`
+
+	// public lobby directory
+	DirectoryRefresh time.Duration `key:"directory.refresh" env:"DIRECTORY_REFRESH" default:"2s" help:"how often directory subscribers are sent changes"`
+
+	// durable archive
+	ArchiveDir string `key:"archive.dir" env:"ARCHIVE_DIR" default:"" help:"directory the database of archived lobbies is kept in, one per instance. lobbies can't be archived while empty"`
+
+	// logging
+	LogLevel         string `key:"log.level" env:"LOG_LEVEL" default:"info" help:"least severe level logged: debug, info, warn or error (reloaded on SIGHUP)"`
+	LogFormat        string `key:"log.format" env:"LOG_FORMAT" default:"text" help:"log output format: text or json"`
+	LogRedactContent bool   `key:"log.redact_content" env:"LOG_REDACT_CONTENT" default:"false" help:"leave message contents out of logs"`
+
+	// messages
+	RenderMessages bool `key:"messages.render" env:"RENDER_MESSAGES" default:"false" help:"sanitize messages and render their markdown server-side"`
+
+	// Redis
+	RedisHost      string        `key:"redis.host" env:"REDIS_HOST" default:"localhost" help:"Redis host"`
+	RedisPort      int           `key:"redis.port" env:"REDIS_PORT" default:"6379" help:"Redis port"`
+	RedisPassword  string        `key:"redis.password" env:"REDIS_PASSWORD" default:"" secret:"true" help:"Redis password"`
+	RedisDB        int           `key:"redis.db" env:"REDIS_DB" default:"0" help:"Redis database number"`
+	KeyPrefix      string        `key:"redis.key_prefix" env:"REDIS_KEY_PREFIX" default:"warpsockets:" help:"prefix of every Redis key and channel"`
+	HistoryMaxLen  int64         `key:"redis.history_max_len" env:"HISTORY_MAX_LEN" default:"500" help:"messages kept per lobby"`
+	Fanout         bool          `key:"redis.fanout" env:"REDIS_FANOUT" default:"false" help:"share broadcasts with other replicas through Redis Pub/Sub"`
+	ReorderWait    time.Duration `key:"redis.reorder_wait" env:"REDIS_REORDER_WAIT" default:"250ms" help:"how long an out-of-order broadcast waits for its predecessors"`
+	LeaseTTL       time.Duration `key:"redis.lease_ttl" env:"REDIS_LEASE_TTL" default:"15s" help:"instance lease, members of an instance that misses it are expired"`
+	Heartbeat      time.Duration `key:"redis.heartbeat" env:"REDIS_HEARTBEAT" default:"5s" help:"how often the instance lease is renewed"`
+	PersistLobbies bool          `key:"redis.persist_lobbies" env:"PERSIST_LOBBIES" default:"false" help:"keep lobbies in Redis across restarts"`
+	ResumeGrace    time.Duration `key:"redis.resume_grace" env:"RESUME_GRACE" default:"2m" help:"how long clients may resume their session after a restart"`
+	MigrateHistory bool          `key:"redis.migrate_history" env:"REDIS_MIGRATE_HISTORY" default:"false" help:"move list histories left by versions before key prefixes into streams, this touches unprefixed lobby:*:messages keys"`
+}
+
+// DefaultConfig returns the configuration with every setting at its default
+func DefaultConfig() Config {
+	var c Config
+	forEachSetting(&c, func(field reflect.Value, tag reflect.StructTag) {
+		if err := setSetting(field, tag.Get("default")); err != nil {
+			panic(fmt.Sprintf("bad default for %s: %v", tag.Get("key"), err))
+		}
+	})
+	return c
+}
+
+// LoadConfig builds the configuration from args (normally os.Args[1:]).
+// Later sources win: defaults < config file (-config or CONFIG_FILE) < environment < flags.
+func LoadConfig(args []string) (Config, error) {
+	return LoadConfigOver(DefaultConfig(), args)
+}
+
+// LoadConfigOver is LoadConfig starting from defaults instead of DefaultConfig, for a program whose defaults
+// differ from the library's (the warpsockets binary serves the frontend, say).
+func LoadConfigOver(defaults Config, args []string) (Config, error) {
+	c := defaults
+
+	fs := flag.NewFlagSet("warpsockets", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a TOML config file")
+	flagValues := make(map[string]*string)
+	forEachSetting(&c, func(field reflect.Value, tag reflect.StructTag) {
+		key := tag.Get("key")
+		flagValues[key] = fs.String(key, "", fmt.Sprintf("%s (env %s, default %q)", tag.Get("help"), tag.Get("env"), formatSetting(field)))
+	})
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return c, err
+		}
+		if err := applySettings(&c, "config file", func(key, env string) (string, bool) {
+			v, ok := values[key]
+			delete(values, key)
+			return v, ok
+		}); err != nil {
+			return c, err
+		}
+		for key := range values {
+			return c, fmt.Errorf("config file: unknown setting %q", key)
+		}
+	}
+
+	if err := applySettings(&c, "environment", func(key, env string) (string, bool) {
+		return os.LookupEnv(env)
+	}); err != nil {
+		return c, err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if err := applySettings(&c, "flag", func(key, env string) (string, bool) {
+		return *flagValues[key], set[key]
+	}); err != nil {
+		return c, err
+	}
+
+	return c, c.validate()
+}
+
+// validate checks settings that parse fine but can't work
+func (c Config) validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.RedisPort <= 0 || c.RedisPort > 65535 {
+		errs = append(errs, fmt.Errorf("redis.port %d is not a valid port", c.RedisPort))
+	}
+	if c.ReadBufferSize <= 0 || c.WriteBufferSize <= 0 {
+		errs = append(errs, errors.New("websocket buffer sizes must be positive"))
+	}
+	if c.UpgradeRetries < 0 || c.SendQueueSlack < 0 || c.RedisDB < 0 {
+		errs = append(errs, errors.New("websocket.upgrade_retries, websocket.send_queue_slack and redis.db can't be negative"))
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		errs = append(errs, errors.New("admin.token must be at least 16 characters"))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %v", err))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log.format must be text or json, not %q", c.LogFormat))
+	}
+	if c.LobbyMaxMembers <= 0 || c.QueueMaxLen <= 0 {
+		errs = append(errs, errors.New("limits.lobby_members and limits.queue_length must be positive"))
+	}
+	if c.IdleTimeout < 0 || c.MaxLifetime < 0 || c.ExpiryWarning < 0 || c.Linger < 0 {
+		errs = append(errs, errors.New("expiry durations cannot be negative"))
+	}
+	if c.MaxConnections < 0 {
+		errs = append(errs, errors.New("limits.connections cannot be negative"))
+	}
+	if c.HistoryMaxLen <= 0 {
+		errs = append(errs, errors.New("redis.history_max_len must be positive"))
+	}
+	for _, d := range []time.Duration{c.DrainTimeout, c.WriteWait, c.ReorderWait, c.LeaseTTL, c.Heartbeat, c.ResumeGrace, c.QueuePoll, c.DirectoryRefresh} {
+		if d <= 0 {
+			errs = append(errs, errors.New("durations must be positive"))
+			break
+		}
+	}
+	if c.Heartbeat >= c.LeaseTTL {
+		errs = append(errs, fmt.Errorf("redis.heartbeat (%s) must be shorter than redis.lease_ttl (%s)", c.Heartbeat, c.LeaseTTL))
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("server.allowed_origins: %q is not an origin like https://example.com", origin))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String prints every setting, one "key = value" per line, with secrets redacted
+func (c Config) String() string {
+	var b strings.Builder
+	forEachSetting(&c, func(field reflect.Value, tag reflect.StructTag) {
+		value := formatSetting(field)
+		if tag.Get("secret") == "true" && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "%s = %s\n", tag.Get("key"), value)
+	})
+	return b.String()
+}
+
+func forEachSetting(c *Config, fn func(field reflect.Value, tag reflect.StructTag)) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fn(v.Field(i), v.Type().Field(i).Tag)
+	}
+}
+
+// applySettings sets every field lookup has a value for
+func applySettings(c *Config, source string, lookup func(key, env string) (string, bool)) error {
+	var errs []error
+	forEachSetting(c, func(field reflect.Value, tag reflect.StructTag) {
+		raw, ok := lookup(tag.Get("key"), tag.Get("env"))
+		if !ok {
+			return
+		}
+		if err := setSetting(field, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %v", source, tag.Get("key"), err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func setSetting(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case []string:
+		field.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(raw string) []string {
+	items := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func formatSetting(field reflect.Value) string {
+	switch v := field.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// readConfigFile reads a TOML config file into settings keyed "section.key", as the strings setSetting expects.
+// arrays become comma separated lists
+func readConfigFile(path string) (map[string]string, error) {
+	var doc map[string]interface{}
+	if _, err := toml.DecodeFile(path, &doc); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	if err := flattenTOML(values, "", doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flattenTOML(values map[string]string, prefix string, table map[string]interface{}) error {
+	for key, value := range table {
+		key = prefix + key
+		if sub, ok := value.(map[string]interface{}); ok {
+			if err := flattenTOML(values, key+".", sub); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, err := tomlSetting(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = raw
+	}
+	return nil
+}
+
+// tomlSetting formats a decoded TOML value the way it would be written in an environment variable
+func tomlSetting(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int64, bool:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			raw, err := tomlSetting(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, raw)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
@@ -0,0 +1,107 @@
+package warpsockets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the example config file parses and matches the defaults
+func TestExampleConfigMatchesDefaults(t *testing.T) {
+	c, err := LoadConfig([]string{"-config", "../warpsockets.example.toml"})
+	if err != nil {
+		t.Fatalf("failed to load example config: %v", err)
+	}
+	if !reflect.DeepEqual(c, DefaultConfig()) {
+		t.Errorf("example config differs from defaults:\n%s\nwant:\n%s", c, DefaultConfig())
+	}
+}
+
+// Test that later sources win: defaults < file < environment < flags
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	file := "[server]\naddr = \":9000\" # comment\n[redis]\nhost = \"file-host\"\nport = 6380\nlease_ttl = \"30s\"\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REDIS_HOST", "env-host")
+	t.Setenv("REDIS_PORT", "6381")
+
+	c, err := LoadConfig([]string{"-config", path, "-redis.port", "6382"})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if c.Addr != ":9000" || c.RedisHost != "env-host" || c.RedisPort != 6382 || c.LeaseTTL != 30*time.Second {
+		t.Errorf("unexpected config:\n%s", c)
+	}
+}
+
+// Test that the file can use any TOML syntax for the settings: multi-line arrays, dotted keys, inline tables
+func TestConfigFileSyntax(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	file := `limits.queue = true
+redis = { host = "file-host", port = 6380 }
+
+[server]
+addr = ":9000"
+allowed_origins = [
+	"https://a.example", # first
+	'https://b.example',
+]
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if c.Addr != ":9000" || c.RedisHost != "file-host" || c.RedisPort != 6380 || !c.WaitingQueue ||
+		!slices.Equal(c.AllowedOrigins, []string{"https://a.example", "https://b.example"}) {
+		t.Errorf("unexpected config:\n%s", c)
+	}
+}
+
+// Test that bad values, unknown keys and impossible combinations are rejected
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+	}{
+		{"unknown key", "[redis]\nhots = \"x\"\n", nil},
+		{"malformed file", "[redis\nhost = \"x\"\n", nil},
+		{"unsupported value", "[redis]\nport = 6379.5\n", nil},
+		{"bad int", "", []string{"-redis.port", "abc"}},
+		{"bad duration", "", []string{"-redis.lease_ttl", "15"}},
+		{"heartbeat too long", "", []string{"-redis.heartbeat", "20s"}},
+		{"bad origin", "", []string{"-server.allowed_origins", "example.com"}},
+	}
+	for _, tt := range tests {
+		args := tt.args
+		if tt.file != "" {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			args = append([]string{"-config", path}, args...)
+		}
+		if _, err := LoadConfig(args); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+// Test that secrets are redacted when the config is printed
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	c := DefaultConfig()
+	c.RedisPassword = "hunter2"
+	if out := c.String(); strings.Contains(out, "hunter2") || !strings.Contains(out, "redis.password = [redacted]") {
+		t.Errorf("password not redacted:\n%s", out)
+	}
+}
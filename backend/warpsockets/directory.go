@@ -0,0 +1,277 @@
+/* Public lobby directory: lobbies their creator made public, listed over HTTP or pushed to WebSocket subscribers */
+package warpsockets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// lobbies listed when a query doesn't say, and the most it may ask for
+const (
+	defaultDirectoryLimit = 50
+	maxDirectoryLimit     = 200
+)
+
+// orders a directory listing can be sorted in
+const (
+	SortPopular = "popular" // most members first (the default)
+	SortActive  = "active"  // most recent message first
+	SortName    = "name"    // alphabetical
+)
+
+// set of public lobby names. private lobbies are never added, so they can't show up in a listing
+func (s *Server) directoryKey() string {
+	return s.cfg.KeyPrefix + "directory"
+}
+
+// One public lobby in the directory.
+type DirectoryEntry struct {
+	Name       string    `json:"name"`
+	Topic      string    `json:"topic,omitempty"`
+	Members    int64     `json:"members"`
+	Capacity   int64     `json:"capacity"`
+	Created    time.Time `json:"created"`
+	LastActive time.Time `json:"lastActive"` // time of the newest message, or the creation time without any
+}
+
+// Filters and orders a directory listing. Taken from the query string of GET /lobbies and /ws/lobbies,
+// and from JSON frames a subscriber sends to change what it's sent.
+type DirectoryQuery struct {
+	Prefix string `json:"prefix"` // case-insensitive lobby name prefix
+	Sort   string `json:"sort"`   // popular, active or name
+	Limit  int    `json:"limit"`
+}
+
+// Reply of GET /lobbies, and the frame sent to subscribers whenever the listing changes.
+type DirectoryListing struct {
+	Type    string           `json:"type"` // always "lobbies"
+	Lobbies []DirectoryEntry `json:"lobbies"`
+}
+
+// normalize fills in defaults and rejects queries the directory can't answer
+func (q *DirectoryQuery) normalize() error {
+	q.Prefix = strings.ToLower(normalizeName(q.Prefix))
+	switch q.Sort {
+	case "":
+		q.Sort = SortPopular
+	case SortPopular, SortActive, SortName:
+	default:
+		return fmt.Errorf("sort must be %s, %s or %s", SortPopular, SortActive, SortName)
+	}
+	switch {
+	case q.Limit < 0:
+		return fmt.Errorf("limit cannot be negative")
+	case q.Limit == 0:
+		q.Limit = defaultDirectoryLimit
+	case q.Limit > maxDirectoryLimit:
+		q.Limit = maxDirectoryLimit
+	}
+	return nil
+}
+
+func parseDirectoryQuery(values url.Values) (DirectoryQuery, error) {
+	q := DirectoryQuery{Prefix: values.Get("prefix"), Sort: values.Get("sort")}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return q, fmt.Errorf("limit must be a number")
+		}
+		q.Limit = n
+	}
+	return q, q.normalize()
+}
+
+// listDirectory returns the public lobbies matching q, in the order it asks for
+func (s *Server) listDirectory(ctx context.Context, q DirectoryQuery) ([]DirectoryEntry, error) {
+	var names []string
+	// SSCAN instead of SMEMBERS so a large directory doesn't block Redis
+	iter := s.redisClient.SScan(ctx, s.directoryKey(), 0, "", 100).Iterator()
+	for iter.Next(ctx) {
+		if strings.HasPrefix(strings.ToLower(iter.Val()), q.Prefix) {
+			names = append(names, iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	members := make([]*redis.IntCmd, len(names))
+	meta := make([]*redis.MapStringStringCmd, len(names))
+	newest := make([]*redis.XMessageSliceCmd, len(names))
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, name := range names {
+			members[i] = pipe.HLen(ctx, s.membersKey(name))
+			meta[i] = pipe.HGetAll(ctx, s.lobbyMetaKey(name))
+			newest[i] = pipe.XRevRangeN(ctx, s.historyKey(name), "+", "-", 1)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	entries := make([]DirectoryEntry, 0, len(names))
+	for i, name := range names {
+		info := meta[i].Val()
+		// skip lobbies being deleted, and anything not explicitly public should the set ever disagree with it
+		if members[i].Val() == 0 || info["public"] != "1" {
+			continue
+		}
+		created, _ := strconv.ParseInt(info["created"], 10, 64)
+		entry := DirectoryEntry{
+			Name:       name,
+			Topic:      info["topic"],
+			Members:    members[i].Val(),
+			Capacity:   s.lobbyCapacity(info["capacity"]),
+			Created:    time.Unix(created, 0).UTC(),
+			LastActive: time.Unix(created, 0).UTC(),
+		}
+		if messages := newest[i].Val(); len(messages) > 0 {
+			entry.LastActive = streamIDTime(messages[0].ID)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch q.Sort {
+		case SortPopular:
+			if a.Members != b.Members {
+				return a.Members > b.Members
+			}
+			if !a.LastActive.Equal(b.LastActive) {
+				return a.LastActive.After(b.LastActive)
+			}
+		case SortActive:
+			if !a.LastActive.Equal(b.LastActive) {
+				return a.LastActive.After(b.LastActive)
+			}
+		}
+		return a.Name < b.Name
+	})
+	if len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
+
+// stream IDs start with the entry's creation time in milliseconds
+func streamIDTime(id string) time.Time {
+	ms, _, _ := strings.Cut(id, "-")
+	n, _ := strconv.ParseInt(ms, 10, 64)
+	return time.UnixMilli(n).UTC()
+}
+
+// handleDirectory lists public lobbies: GET /lobbies?prefix=&sort=popular|active|name&limit=
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseDirectoryQuery(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: err.Error(), Code: "invalid_query"})
+		return
+	}
+	entries, err := s.listDirectory(r.Context(), q)
+	if err != nil {
+		s.logger.Error("error listing lobby directory", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to list lobbies, try again."})
+		return
+	}
+	writeJSON(w, http.StatusOK, DirectoryListing{Type: "lobbies", Lobbies: entries})
+}
+
+// handleDirectorySocket streams the directory to a subscriber. It's sent the listing straight away and again
+// whenever it changes (checked every directory.refresh). Sending a DirectoryQuery frame changes the listing.
+func (s *Server) handleDirectorySocket(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	q, err := parseDirectoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.metrics.upgradeFailures.Inc()
+		s.logger.Debug("error upgrading directory subscription", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+	logger := s.logger.With("conn", generateConnectionID(), "remote", r.RemoteAddr)
+	logger.Debug("directory subscriber connected")
+
+	// the reader only hands queries over, this goroutine is the connection's only writer
+	queries := make(chan DirectoryQuery)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(queries)
+		for {
+			var next DirectoryQuery
+			if err := conn.ReadJSON(&next); err != nil {
+				return
+			}
+			select {
+			case queries <- next:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var last []byte
+	send := func(v interface{}) bool {
+		frame, err := json.Marshal(v)
+		if err != nil || bytes.Equal(frame, last) {
+			return err == nil
+		}
+		last = frame
+		conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteWait))
+		return conn.WriteMessage(websocket.TextMessage, frame) == nil
+	}
+	refresh := func() bool {
+		entries, err := s.listDirectory(r.Context(), q)
+		if err != nil {
+			logger.Warn("error listing lobby directory", "err", err)
+			return true
+		}
+		return send(DirectoryListing{Type: "lobbies", Lobbies: entries})
+	}
+
+	ticker := time.NewTicker(s.cfg.DirectoryRefresh)
+	defer ticker.Stop()
+	for ok := refresh(); ok; {
+		select {
+		case next, open := <-queries:
+			if !open {
+				logger.Debug("directory subscriber disconnected")
+				return
+			}
+			if err := next.normalize(); err != nil {
+				ok = send(ErrorResponse{Type: "error", Message: err.Error(), Code: "invalid_query"})
+				continue
+			}
+			q = next
+			ok = refresh()
+		case <-ticker.C:
+			if s.draining.Load() {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+				return
+			}
+			ok = refresh()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package warpsockets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Test that directory queries get defaults, are capped, and are rejected when they can't be answered
+func TestParseDirectoryQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    DirectoryQuery
+		wantErr bool
+	}{
+		{"", DirectoryQuery{Sort: SortPopular, Limit: defaultDirectoryLimit}, false},
+		{"prefix=%20Retro%20%20Games&sort=active&limit=5", DirectoryQuery{Prefix: "retro games", Sort: SortActive, Limit: 5}, false},
+		{"limit=100000", DirectoryQuery{Sort: SortPopular, Limit: maxDirectoryLimit}, false},
+		{"sort=newest", DirectoryQuery{}, true},
+		{"limit=-1", DirectoryQuery{}, true},
+		{"limit=ten", DirectoryQuery{}, true},
+	}
+
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		got, err := parseDirectoryQuery(values)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// Test that a message's time is read back from its stream ID
+func TestStreamIDTime(t *testing.T) {
+	want := time.UnixMilli(1700000000123).UTC()
+	if got := streamIDTime("1700000000123-4"); !got.Equal(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+// Test that GET /lobbies rejects bad queries before touching Redis
+func TestDirectoryRejectsBadQuery(t *testing.T) {
+	s := newTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for query, want := range map[string]int{
+		"?sort=newest": http.StatusBadRequest,
+		// Redis is unreachable, so a good query ends in a server error
+		"?sort=name": http.StatusInternalServerError,
+	} {
+		resp, err := http.Get(srv.URL + "/lobbies" + query)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: got %d, want %d", query, resp.StatusCode, want)
+		}
+	}
+}
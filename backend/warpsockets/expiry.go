@@ -0,0 +1,157 @@
+/* Lobby expiry: lobbies idle for expiry.idle, or open for expiry.lifetime, are closed after warning their members */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// why a lobby expires
+const (
+	ExpiryIdle     = "idle"
+	ExpiryLifetime = "lifetime"
+	ExpiryClosed   = "closed" // by an administrator, see handleAdminCloseLobby
+)
+
+// Sent to a lobby's members expiry.warning before it expires ("expiry_warning"), and when it does ("expired")
+// just before they're disconnected.
+type LobbyExpiry struct {
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"` // idle or lifetime
+	ExpiresAt time.Time `json:"expiresAt"`
+	Message   string    `json:"message"`
+}
+
+// lobbyDeadline works out when a lobby expires from its meta, and the time of its newest message.
+// Messages (including arrivals and departures) keep a lobby from going idle, nothing extends its lifetime.
+// ok is false if neither limit applies to the lobby.
+func (s *Server) lobbyDeadline(created, lastActive time.Time) (deadline time.Time, reason string, ok bool) {
+	if s.cfg.IdleTimeout > 0 && !lastActive.IsZero() {
+		deadline, reason, ok = lastActive.Add(s.cfg.IdleTimeout), ExpiryIdle, true
+	}
+	if s.cfg.MaxLifetime > 0 && !created.IsZero() {
+		if end := created.Add(s.cfg.MaxLifetime); !ok || end.Before(deadline) {
+			deadline, reason, ok = end, ExpiryLifetime, true
+		}
+	}
+	return deadline, reason, ok
+}
+
+// expireLobbies warns and closes this instance's members of lobbies that are due to expire. Every instance checks
+// its own members on its heartbeat, and the lobby is deleted once the last of them is disconnected, like any
+// lobby that empties.
+func (s *Server) expireLobbies(ctx context.Context) {
+	if s.cfg.IdleTimeout <= 0 && s.cfg.MaxLifetime <= 0 {
+		return
+	}
+
+	var lobbies []string
+	s.lobbyConnections.Range(func(key, value interface{}) bool {
+		lobbies = append(lobbies, key.(string))
+		return true
+	})
+	// forget warnings for lobbies this instance no longer has members in
+	s.expiryWarned.Range(func(key, value interface{}) bool {
+		if _, ok := s.lobbyConnections.Load(key); !ok {
+			s.expiryWarned.Delete(key)
+		}
+		return true
+	})
+	if len(lobbies) == 0 {
+		return
+	}
+
+	created := make([]*redis.StringCmd, len(lobbies))
+	newest := make([]*redis.XMessageSliceCmd, len(lobbies))
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, lobby := range lobbies {
+			created[i] = pipe.HGet(ctx, s.lobbyMetaKey(lobby), "created")
+			newest[i] = pipe.XRevRangeN(ctx, s.historyKey(lobby), "+", "-", 1)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		s.logger.Error("error checking lobby expiry", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for i, lobby := range lobbies {
+		var createdAt, lastActive time.Time
+		if n, err := strconv.ParseInt(created[i].Val(), 10, 64); err == nil {
+			createdAt = time.Unix(n, 0)
+			lastActive = createdAt
+		}
+		if messages := newest[i].Val(); len(messages) > 0 {
+			lastActive = streamIDTime(messages[0].ID)
+		}
+
+		deadline, reason, ok := s.lobbyDeadline(createdAt, lastActive)
+		switch {
+		case !ok:
+		case !now.Before(deadline):
+			s.expireLobby(ctx, lobby, reason, deadline)
+		case now.Add(s.cfg.ExpiryWarning).After(deadline):
+			s.warnLobbyExpiry(lobby, reason, deadline)
+		}
+	}
+}
+
+// warnLobbyExpiry tells the lobby's members on this instance when it expires, once per deadline
+func (s *Server) warnLobbyExpiry(lobby, reason string, deadline time.Time) {
+	if warned, ok := s.expiryWarned.Load(lobby); ok && warned.(time.Time).Equal(deadline) {
+		return
+	}
+	s.expiryWarned.Store(lobby, deadline)
+
+	message := fmt.Sprintf("This lobby closes in %s.", time.Until(deadline).Round(time.Second))
+	if reason == ExpiryIdle {
+		message = fmt.Sprintf("This lobby closes in %s unless someone sends a message.", time.Until(deadline).Round(time.Second))
+	}
+	frame, _ := json.Marshal(LobbyExpiry{Type: "expiry_warning", Reason: reason, ExpiresAt: deadline.UTC(), Message: message})
+
+	conns, _ := s.lobbyConnections.Load(lobby)
+	if conns == nil {
+		return
+	}
+	for _, lobbyUser := range conns.([]*LobbyUser) {
+		lobbyUser.enqueue(frame)
+	}
+	s.logger.Info("warned lobby of expiry", "lobby", lobby, "reason", reason, "expires", deadline)
+}
+
+// expireLobby disconnects the lobby's members on this instance. Its queue is turned away too, so the lobby is
+// deleted rather than handed to whoever was waiting for a seat.
+func (s *Server) expireLobby(ctx context.Context, lobby, reason string, deadline time.Time) {
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.lobbyMetaKey(lobby), "expired", reason)
+		pipe.Del(ctx, s.queueKey(lobby), s.queueSeenKey(lobby))
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error marking lobby expired", "lobby", lobby, "err", err)
+	}
+
+	message := "This lobby was closed after its maximum lifetime."
+	if reason == ExpiryIdle {
+		message = "This lobby was closed for inactivity."
+	}
+	frame, _ := json.Marshal(LobbyExpiry{Type: "expired", Reason: reason, ExpiresAt: deadline.UTC(), Message: message})
+	s.closeLocal(lobby, frame, nil, "lobby expired")
+	s.expiryWarned.Delete(lobby)
+	s.logger.Info("lobby expired", "lobby", lobby, "reason", reason)
+}
+
+// lobbyClosed reports whether the lobby expired or was deleted, for users waiting for a seat in it
+func (s *Server) lobbyClosed(ctx context.Context, lobby string) bool {
+	meta, err := s.redisClient.HGetAll(ctx, s.lobbyMetaKey(lobby)).Result()
+	if err != nil {
+		return false
+	}
+	return len(meta) == 0 || meta["expired"] != ""
+}
@@ -0,0 +1,63 @@
+package warpsockets
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Test that a lobby expires at whichever of its idle and lifetime limits comes first
+func TestLobbyDeadline(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		idle, life   time.Duration
+		lastActive   time.Time
+		wantOK       bool
+		wantDeadline time.Time
+		wantReason   string
+	}{
+		{"no limits", 0, 0, created.Add(time.Minute), false, time.Time{}, ""},
+		{"idle only", 10 * time.Minute, 0, created.Add(time.Minute), true, created.Add(11 * time.Minute), ExpiryIdle},
+		{"lifetime only", 0, time.Hour, created.Add(time.Minute), true, created.Add(time.Hour), ExpiryLifetime},
+		{"idle first", 10 * time.Minute, time.Hour, created.Add(time.Minute), true, created.Add(11 * time.Minute), ExpiryIdle},
+		{"lifetime first", 10 * time.Minute, time.Hour, created.Add(55 * time.Minute), true, created.Add(time.Hour), ExpiryLifetime},
+	}
+
+	for _, tt := range tests {
+		s := newTestServer(t, nil)
+		s.cfg.IdleTimeout, s.cfg.MaxLifetime = tt.idle, tt.life
+		deadline, reason, ok := s.lobbyDeadline(created, tt.lastActive)
+		if ok != tt.wantOK || !deadline.Equal(tt.wantDeadline) || reason != tt.wantReason {
+			t.Errorf("%s: got %v %q %v, want %v %q %v", tt.name, deadline, reason, ok, tt.wantDeadline, tt.wantReason, tt.wantOK)
+		}
+	}
+}
+
+// Test that members are warned once per deadline, and again if the deadline moves
+func TestWarnLobbyExpiry(t *testing.T) {
+	s := newTestServer(t, nil)
+	lobbyUser := &LobbyUser{User: "grant", send: make(chan []byte, 4), logger: s.logger}
+	s.lobbyConnections.Store("sleepy", []*LobbyUser{lobbyUser})
+
+	deadline := time.Now().Add(time.Minute)
+	s.warnLobbyExpiry("sleepy", ExpiryIdle, deadline)
+	s.warnLobbyExpiry("sleepy", ExpiryIdle, deadline)
+	if len(lobbyUser.send) != 1 {
+		t.Fatalf("got %d warnings, want 1", len(lobbyUser.send))
+	}
+
+	var warning LobbyExpiry
+	if err := json.Unmarshal(<-lobbyUser.send, &warning); err != nil {
+		t.Fatalf("failed to decode warning: %v", err)
+	}
+	if warning.Type != "expiry_warning" || warning.Reason != ExpiryIdle || !warning.ExpiresAt.Equal(deadline) {
+		t.Errorf("got %+v", warning)
+	}
+
+	// someone sent a message, so the lobby goes idle later
+	s.warnLobbyExpiry("sleepy", ExpiryIdle, deadline.Add(time.Minute))
+	if len(lobbyUser.send) != 1 {
+		t.Errorf("expected a new warning for the new deadline")
+	}
+}
@@ -0,0 +1,264 @@
+/* Transcript export: renders a lobby's history as JSON, Markdown, plain text or HTML for its members to keep */
+package warpsockets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// how long the download link sent with an export keeps working
+const exportLinkTTL = 10 * time.Minute
+
+// transcript formats
+const (
+	ExportJSON     = "json"
+	ExportMarkdown = "markdown"
+	ExportText     = "text"
+	ExportHTML     = "html"
+)
+
+var exportFormats = map[string]struct{ ext, contentType string }{
+	ExportJSON:     {"json", "application/json"},
+	ExportMarkdown: {"md", "text/markdown; charset=utf-8"},
+	ExportText:     {"txt", "text/plain; charset=utf-8"},
+	ExportHTML:     {"html", "text/html; charset=utf-8"},
+}
+
+// Sent by a member to export the lobby's history.
+// The tree has no edits or reactions yet, so arrivals and departures are the only optional content.
+type ExportRequest struct {
+	Type       string `json:"type"` // always "export"
+	Format     string `json:"format"`
+	HideSystem bool   `json:"hideSystem,omitempty"` // leave out arrivals and departures
+}
+
+// Reply to an ExportRequest. URL downloads the same transcript for exportLinkTTL, from any instance, as long as
+// the member who asked for it is still in the lobby.
+type TranscriptExport struct {
+	Type        string `json:"type"` // always "export"
+	Format      string `json:"format"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+	URL         string `json:"url,omitempty"`
+}
+
+// Lossless JSON transcript: every stored field of every message.
+type Transcript struct {
+	Lobby      string    `json:"lobby"`
+	ExportedAt time.Time `json:"exportedAt"`
+	Messages   []Message `json:"messages"`
+}
+
+// export:<token> is a hash of lobby, user, format and hideSystem behind a download link
+func (s *Server) exportKey(token string) string {
+	return s.cfg.KeyPrefix + "export:" + token
+}
+
+func isSystemMessage(message Message) bool {
+	return message.Type[0] != ""
+}
+
+// renderTranscript renders messages in format. Arrivals and departures are left out with hideSystem.
+func renderTranscript(lobby string, messages []Message, format string, hideSystem bool, now time.Time) ([]byte, error) {
+	kept := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		if hideSystem && isSystemMessage(message) {
+			continue
+		}
+		kept = append(kept, message)
+	}
+
+	var b bytes.Buffer
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(&b)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(Transcript{Lobby: lobby, ExportedAt: now.UTC(), Messages: kept})
+		return b.Bytes(), err
+
+	case ExportMarkdown:
+		fmt.Fprintf(&b, "# %s\n\n_Exported from warpsockets on %s_\n", lobby, now.UTC().Format("Jan 2, 2006 15:04 MST"))
+		for _, message := range kept {
+			if isSystemMessage(message) {
+				fmt.Fprintf(&b, "\n_%s · %s_\n", message.Content, message.FormattedTime)
+				continue
+			}
+			// the text as typed, so its markdown survives
+			content := message.Content
+			if message.RawContent != "" {
+				content = message.RawContent
+			}
+			fmt.Fprintf(&b, "\n**%s** · %s\n\n%s\n", message.User, message.FormattedTime, content)
+		}
+		return b.Bytes(), nil
+
+	case ExportText:
+		fmt.Fprintf(&b, "%s - exported from warpsockets on %s\n\n", lobby, now.UTC().Format("Jan 2, 2006 15:04 MST"))
+		for _, message := range kept {
+			if isSystemMessage(message) {
+				fmt.Fprintf(&b, "[%s] * %s\n", message.FormattedTime, message.Content)
+				continue
+			}
+			// continuation lines line up under the first
+			content := strings.ReplaceAll(message.Content, "\n", "\n    ")
+			fmt.Fprintf(&b, "[%s] %s: %s\n", message.FormattedTime, message.User, content)
+		}
+		return b.Bytes(), nil
+
+	case ExportHTML:
+		err := transcriptTemplate.Execute(&b, struct {
+			Lobby      string
+			ExportedAt string
+			Messages   []Message
+		}{lobby, now.UTC().Format("Jan 2, 2006 15:04 MST"), kept})
+		return b.Bytes(), err
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// self-contained page styled like the lobby. HTML was sanitized when the message was rendered (see markdown.go)
+var transcriptTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"trusted": func(s string) template.HTML { return template.HTML(s) },
+	"system":  isSystemMessage,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Lobby}} - warpsockets</title>
+<style>
+  body { margin: 0; padding: 2rem 1rem; background-color: rgba(47, 43, 36, 0.982); color: rgb(226, 245, 218);
+    font-family: "Gohu Nerd Font", "ProggyVector", monospace; }
+  main { max-width: 800px; margin: 0 auto; padding: 1rem; border-radius: 4px; background-color: rgba(51, 53, 57, 0.775); }
+  h1 { margin: 0; font-size: 150%; }
+  .exported { margin: 0.25rem 0 1rem; color: #b5b3b0; font-size: 85%; }
+  .message { padding: 0.4rem 0.5rem; border-radius: 4px; }
+  .message:hover { background-color: rgb(51, 53, 57); }
+  .user { font-weight: 700; }
+  .time { margin-left: 0.5rem; color: #b5b3b0; font-size: 85%; }
+  .content { margin: 0.2rem 0 0; color: antiquewhite; white-space: pre-wrap; word-wrap: break-word; }
+  .system { color: #b5b3b0; font-style: italic; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Lobby}}</h1>
+<p class="exported">Exported from warpsockets on {{.ExportedAt}}</p>
+{{range .Messages}}{{if system .}}<div class="message system">{{.Content}}<span class="time">{{.FormattedTime}}</span></div>
+{{else}}<div class="message">
+  <span class="user" style="color: {{.Color}}">{{.User}}</span><span class="time">{{.FormattedTime}}</span>
+  <div class="content">{{if .HTML}}{{trusted .HTML}}{{else}}{{.Content}}{{end}}</div>
+</div>
+{{end}}{{end}}</main>
+</body>
+</html>
+`))
+
+// exportFilename is a safe download name for the lobby's transcript
+func exportFilename(lobby, format string, now time.Time) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, lobby)
+	return fmt.Sprintf("warpsockets-%s-%s.%s", name, now.UTC().Format("20060102-1504"), exportFormats[format].ext)
+}
+
+// handleExportRequest replies to a member's ExportRequest with the transcript and a link to download it again
+func (s *Server) handleExportRequest(lobby string, lobbyUser *LobbyUser, msg []byte) {
+	var request ExportRequest
+	if err := json.Unmarshal(msg, &request); err != nil {
+		lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Invalid export request.", Code: "invalid_export"})
+		return
+	}
+	if _, ok := exportFormats[request.Format]; !ok {
+		lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Export format must be json, markdown, text or html.", Code: "invalid_export"})
+		return
+	}
+
+	now := time.Now()
+	content, err := renderTranscript(lobby, s.getExistingMessages(lobby), request.Format, request.HideSystem, now)
+	if err != nil {
+		lobbyUser.logger.Error("error rendering transcript", "format", request.Format, "err", err)
+		lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Unable to export the lobby, try again."})
+		return
+	}
+
+	export := TranscriptExport{
+		Type:        "export",
+		Format:      request.Format,
+		Filename:    exportFilename(lobby, request.Format, now),
+		ContentType: exportFormats[request.Format].contentType,
+		Content:     string(content),
+	}
+	token := uuid.New().String()
+	ctx := context.Background()
+	err = s.redisClient.HSet(ctx, s.exportKey(token), "lobby", lobby, "user", lobbyUser.User,
+		"format", request.Format, "hideSystem", request.HideSystem).Err()
+	if err == nil {
+		err = s.redisClient.Expire(ctx, s.exportKey(token), exportLinkTTL).Err()
+	}
+	if err != nil {
+		lobbyUser.logger.Error("error storing export link", "err", err)
+	} else {
+		export.URL = "/export/" + token
+	}
+
+	lobbyUser.enqueueJSON(export)
+	lobbyUser.logger.Info("exported lobby transcript", "format", request.Format, "bytes", len(content))
+}
+
+// handleExportDownload serves a transcript from a link sent to a member: GET /export/{token}
+// The token is all it takes, so the link stops working when that member leaves the lobby rather than letting
+// whoever else gets hold of it read on.
+func (s *Server) handleExportDownload(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	link, err := s.redisClient.HGetAll(r.Context(), s.exportKey(token)).Result()
+	if err != nil {
+		s.logger.Error("error loading export link", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to export the lobby, try again."})
+		return
+	}
+	if len(link) == 0 {
+		writeJSON(w, http.StatusNotFound, Response{Type: "error", Message: "This export link has expired."})
+		return
+	}
+
+	member, err := s.redisClient.HExists(r.Context(), s.membersKey(link["lobby"]), link["user"]).Result()
+	if err != nil {
+		s.logger.Error("error checking lobby member", "lobby", link["lobby"], "user", link["user"], "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to export the lobby, try again."})
+		return
+	}
+	if !member {
+		writeJSON(w, http.StatusForbidden, Response{Type: "error", Message: "This export link only works while you're in the lobby."})
+		return
+	}
+
+	now := time.Now()
+	hideSystem, _ := strconv.ParseBool(link["hideSystem"])
+	content, err := renderTranscript(link["lobby"], s.getExistingMessages(link["lobby"]), link["format"], hideSystem, now)
+	if err != nil {
+		s.logger.Error("error rendering transcript", "lobby", link["lobby"], "format", link["format"], "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to export the lobby, try again."})
+		return
+	}
+
+	w.Header().Set("Content-Type", exportFormats[link["format"]].contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(link["lobby"], link["format"], now)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	s.logger.Info("downloaded lobby transcript", "lobby", link["lobby"], "user", link["user"], "format", link["format"])
+}
@@ -0,0 +1,154 @@
+package warpsockets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportTestMessages() []Message {
+	at := time.Date(2024, 3, 1, 15, 4, 0, 0, time.UTC)
+	return []Message{
+		{ID: "1", Type: [2]string{"arrived", "grant"}, Lobby: "retro", User: "System", Content: "grant has arrived.", Color: systemColor, Time: at, FormattedTime: "3:04 PM"},
+		{ID: "2", Lobby: "retro", User: "grant", Content: "<b>hi</b>", RawContent: "**hi**", Color: "#ff0000", Time: at, FormattedTime: "3:04 PM"},
+		{ID: "3", Lobby: "retro", User: "ada", Content: "<script>alert(1)</script>", Color: "#00ff00", Time: at, FormattedTime: "3:05 PM"},
+	}
+}
+
+// Test that the JSON transcript keeps every field of every message
+func TestRenderTranscriptJSON(t *testing.T) {
+	messages := exportTestMessages()
+	out, err := renderTranscript("retro", messages, ExportJSON, false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var transcript Transcript
+	if err := json.Unmarshal(out, &transcript); err != nil {
+		t.Fatalf("transcript isn't valid JSON: %v", err)
+	}
+	if transcript.Lobby != "retro" || len(transcript.Messages) != len(messages) {
+		t.Fatalf("got %+v", transcript)
+	}
+	for i, message := range transcript.Messages {
+		if !message.Time.Equal(messages[i].Time) {
+			t.Errorf("message %d: time %v want %v", i, message.Time, messages[i].Time)
+		}
+		message.Time = messages[i].Time
+		if got, want := message, messages[i]; got.ID != want.ID || got.Type != want.Type || got.RawContent != want.RawContent || got.Color != want.Color {
+			t.Errorf("message %d: got %+v want %+v", i, got, want)
+		}
+	}
+}
+
+// Test that the readable formats include arrivals and departures unless asked not to
+func TestRenderTranscriptSystemEvents(t *testing.T) {
+	for _, format := range []string{ExportMarkdown, ExportText, ExportHTML} {
+		out, err := renderTranscript("retro", exportTestMessages(), format, false, time.Now())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", format, err)
+		}
+		if !strings.Contains(string(out), "grant has arrived.") {
+			t.Errorf("%s: missing the arrival:\n%s", format, out)
+		}
+
+		out, err = renderTranscript("retro", exportTestMessages(), format, true, time.Now())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", format, err)
+		}
+		if strings.Contains(string(out), "grant has arrived.") {
+			t.Errorf("%s: arrival wasn't left out:\n%s", format, out)
+		}
+	}
+}
+
+// Test that markdown keeps the text as typed, and plain text lines read like the lobby
+func TestRenderTranscriptReadable(t *testing.T) {
+	out, _ := renderTranscript("retro", exportTestMessages(), ExportMarkdown, false, time.Now())
+	if !strings.Contains(string(out), "**grant** · 3:04 PM\n\n**hi**") {
+		t.Errorf("markdown didn't use the raw content:\n%s", out)
+	}
+
+	out, _ = renderTranscript("retro", exportTestMessages(), ExportText, false, time.Now())
+	for _, want := range []string{"[3:04 PM] * grant has arrived.\n", "[3:05 PM] ada: <script>alert(1)</script>\n"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("text transcript missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// Test that the HTML transcript escapes content that wasn't sanitized, and colors names like the lobby
+func TestRenderTranscriptHTML(t *testing.T) {
+	messages := exportTestMessages()
+	messages[1].HTML = "<strong>hi</strong>"
+	out, err := renderTranscript("retro", messages, ExportHTML, false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	page := string(out)
+	if strings.Contains(page, "<script>alert(1)</script>") {
+		t.Errorf("message content wasn't escaped:\n%s", page)
+	}
+	for _, want := range []string{"&lt;script&gt;", "<strong>hi</strong>", `style="color: #ff0000"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page missing %q", want)
+		}
+	}
+}
+
+func TestRenderTranscriptUnknownFormat(t *testing.T) {
+	if _, err := renderTranscript("retro", nil, "pdf", false, time.Now()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// Test that lobby names can't break out of the download filename
+func TestExportFilename(t *testing.T) {
+	now := time.Date(2024, 3, 1, 15, 4, 0, 0, time.UTC)
+	tests := []struct{ lobby, format, want string }{
+		{"retro", ExportJSON, "warpsockets-retro-20240301-1504.json"},
+		{"Retro Games", ExportMarkdown, "warpsockets-retro-games-20240301-1504.md"},
+		{`../"x"`, ExportHTML, "warpsockets-----x--20240301-1504.html"},
+	}
+	for _, tt := range tests {
+		if got := exportFilename(tt.lobby, tt.format, now); got != tt.want {
+			t.Errorf("%q: got %q want %q", tt.lobby, got, tt.want)
+		}
+	}
+}
+
+// Test that a download link fails cleanly when Redis can't be reached
+func TestExportDownloadRedisDown(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export/abc", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+// Test that a download link only works while the member it was sent to is in the lobby
+func TestExportDownloadRequiresMembership(t *testing.T) {
+	s, mr := newRedisTestServer(t, nil)
+	mr.HSet(s.exportKey("abc"), "lobby", "retro", "user", "grant", "format", ExportJSON, "hideSystem", "false")
+	mr.HSet(s.membersKey("retro"), "grant", s.instanceID)
+	download := func(token string) int {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export/"+token, nil))
+		return rec.Code
+	}
+
+	if status := download("abc"); status != http.StatusOK {
+		t.Errorf("member: got status %d want %d", status, http.StatusOK)
+	}
+	mr.HDel(s.membersKey("retro"), "grant")
+	if status := download("abc"); status != http.StatusForbidden {
+		t.Errorf("after leaving: got status %d want %d", status, http.StatusForbidden)
+	}
+	if status := download("missing"); status != http.StatusNotFound {
+		t.Errorf("unknown token: got status %d want %d", status, http.StatusNotFound)
+	}
+}
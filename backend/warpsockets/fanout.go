@@ -0,0 +1,216 @@
+/* Cross-instance broadcast fan-out through Redis Pub/Sub */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Wraps every frame published to a lobby's channel.
+type fanoutEnvelope struct {
+	Instance string          `json:"instance"`
+	Sender   string          `json:"sender,omitempty"` // connection ID of the sender, which doesn't get its own message back
+	Seq      int64           `json:"seq,omitempty"`    // 0 for frames that aren't part of the lobby history
+	To       []string        `json:"to,omitempty"`     // only deliver to these users (empty means everyone)
+	Close    string          `json:"close,omitempty"`  // disconnect the recipients after the payload, with this reason
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func (s *Server) fanoutChannel(lobby string) string {
+	return s.lobbyKey(lobby, "broadcast")
+}
+
+/* open the shared subscription and start delivering published frames */
+func (s *Server) initFanout() {
+	s.fanoutPubSub = s.redisClient.Subscribe(context.Background())
+	go s.receiveFanout()
+	s.logger.Info("Redis fan-out enabled", "instance", s.instanceID)
+}
+
+func (s *Server) closeFanout() {
+	if s.fanoutPubSub == nil {
+		return
+	}
+	if err := s.fanoutPubSub.Close(); err != nil {
+		s.logger.Error("error closing Redis subscription", "err", err)
+	}
+}
+
+// subscribeLobby starts receiving a lobby's broadcasts. called when the lobby gets its first local connection
+func (s *Server) subscribeLobby(lobby string) {
+	if !s.cfg.Fanout {
+		return
+	}
+	ctx := context.Background()
+
+	// anything sequenced before this point is already in the history the new user is sent
+	seq, err := s.redisClient.Get(ctx, s.sequenceKey(lobby)).Int64()
+	if err != nil && err != redis.Nil {
+		s.logger.Error("error reading lobby sequence", "lobby", lobby, "err", err)
+	}
+	s.lobbySequencers.Store(lobby, &lobbySequencer{server: s, lobby: lobby, next: seq + 1, pending: make(map[int64]fanoutEnvelope)})
+
+	if err := s.fanoutPubSub.Subscribe(ctx, s.fanoutChannel(lobby)); err != nil {
+		s.logger.Error("error subscribing to lobby", "lobby", lobby, "err", err)
+	}
+}
+
+// unsubscribeLobby stops receiving a lobby's broadcasts once its last local connection is gone
+func (s *Server) unsubscribeLobby(lobby string) {
+	if !s.cfg.Fanout {
+		return
+	}
+	if err := s.fanoutPubSub.Unsubscribe(context.Background(), s.fanoutChannel(lobby)); err != nil {
+		s.logger.Error("error unsubscribing from lobby", "lobby", lobby, "err", err)
+	}
+	if sequencer, ok := s.lobbySequencers.LoadAndDelete(lobby); ok {
+		sequencer.(*lobbySequencer).stop()
+	}
+}
+
+// publishFrame sends a frame to every instance hosting the lobby (including this one).
+// Without fan-out (redis.fanout) broadcasts only reach this process's sockets.
+func (s *Server) publishFrame(lobby string, frame []byte, seq int64, senderID string, to []string) {
+	if !s.cfg.Fanout {
+		s.deliverLocal(lobby, frame, senderID, to)
+		return
+	}
+
+	envelopeJSON, err := json.Marshal(fanoutEnvelope{Instance: s.instanceID, Sender: senderID, Seq: seq, To: to, Payload: frame})
+	if err != nil {
+		s.logger.Error("error serializing broadcast envelope", "lobby", lobby, "err", err)
+		return
+	}
+	if err := s.redisClient.Publish(context.Background(), s.fanoutChannel(lobby), envelopeJSON).Err(); err != nil {
+		s.logger.Error("error publishing to lobby", "lobby", lobby, "err", err)
+	}
+}
+
+// publishClose sends frame to the lobby's users (only those in to, if set) on every instance, then disconnects them.
+// Used by the admin API to kick users and close lobbies.
+func (s *Server) publishClose(lobby string, frame []byte, to []string, reason string) {
+	if !s.cfg.Fanout {
+		s.closeLocal(lobby, frame, to, reason)
+		return
+	}
+
+	envelopeJSON, err := json.Marshal(fanoutEnvelope{Instance: s.instanceID, To: to, Close: reason, Payload: frame})
+	if err != nil {
+		s.logger.Error("error serializing close envelope", "lobby", lobby, "err", err)
+		return
+	}
+	if err := s.redisClient.Publish(context.Background(), s.fanoutChannel(lobby), envelopeJSON).Err(); err != nil {
+		s.logger.Error("error publishing to lobby", "lobby", lobby, "err", err)
+	}
+}
+
+// receiveFanout hands every published frame to its lobby's sequencer until the subscription is closed
+func (s *Server) receiveFanout() {
+	for msg := range s.fanoutPubSub.Channel() {
+		lobby := strings.TrimSuffix(strings.TrimPrefix(msg.Channel, s.cfg.KeyPrefix+"lobby:"), ":broadcast")
+
+		var envelope fanoutEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+			s.logger.Error("error deserializing broadcast envelope", "lobby", lobby, "err", err)
+			continue
+		}
+
+		sequencer, ok := s.lobbySequencers.Load(lobby)
+		if !ok {
+			// the last local user left while this was in flight
+			continue
+		}
+		sequencer.(*lobbySequencer).push(envelope)
+	}
+}
+
+// Restores sequence order for a lobby's messages. Two instances can INCR and PUBLISH in opposite orders,
+// so a message that arrives early is held until its predecessors show up (or redis.reorder_wait passes).
+type lobbySequencer struct {
+	mu      sync.Mutex
+	server  *Server
+	lobby   string
+	next    int64
+	pending map[int64]fanoutEnvelope
+	timer   *time.Timer
+}
+
+func (s *lobbySequencer) push(envelope fanoutEnvelope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case envelope.Seq == 0 || envelope.Seq < s.next:
+		// unsequenced, or too late to reorder
+		s.deliver(envelope)
+	case envelope.Seq == s.next:
+		s.deliver(envelope)
+		s.next++
+		s.drain()
+	default:
+		s.pending[envelope.Seq] = envelope
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.server.cfg.ReorderWait, s.skipGap)
+		}
+	}
+}
+
+// skipGap gives up on a missing sequence number and delivers whatever is waiting behind it
+func (s *lobbySequencer) skipGap() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timer = nil
+	if len(s.pending) == 0 {
+		return
+	}
+	lowest := int64(-1)
+	for seq := range s.pending {
+		if lowest < 0 || seq < lowest {
+			lowest = seq
+		}
+	}
+	s.next = lowest
+	s.drain()
+}
+
+// drain delivers consecutive pending messages. must hold s.mu
+func (s *lobbySequencer) drain() {
+	for {
+		envelope, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		s.deliver(envelope)
+		s.next++
+	}
+	if len(s.pending) == 0 && s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	} else if len(s.pending) > 0 && s.timer == nil {
+		s.timer = time.AfterFunc(s.server.cfg.ReorderWait, s.skipGap)
+	}
+}
+
+func (s *lobbySequencer) deliver(envelope fanoutEnvelope) {
+	if envelope.Close != "" {
+		s.server.closeLocal(s.lobby, envelope.Payload, envelope.To, envelope.Close)
+		return
+	}
+	s.server.deliverLocal(s.lobby, envelope.Payload, envelope.Sender, envelope.To)
+}
+
+func (s *lobbySequencer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
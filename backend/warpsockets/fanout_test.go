@@ -0,0 +1,112 @@
+package warpsockets
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sequencerTestLobby sets up a lobby with one local connection, without a socket behind it, and returns its
+// sequencer and the queue its frames land in
+func sequencerTestLobby(t *testing.T, reorderWait time.Duration) (*lobbySequencer, *LobbyUser) {
+	t.Helper()
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.ReorderWait = reorderWait
+	})
+	receiver := &LobbyUser{ID: "receiver", User: "ada", send: make(chan []byte, 16), logger: s.logger, dropped: s.metrics.slowConsumers}
+	s.lobbyConnections.Store("retro", []*LobbyUser{receiver})
+	sequencer := &lobbySequencer{server: s, lobby: "retro", next: 1, pending: make(map[int64]fanoutEnvelope)}
+	t.Cleanup(sequencer.stop)
+	return sequencer, receiver
+}
+
+func sequencedEnvelope(seq int64, sender string) fanoutEnvelope {
+	return fanoutEnvelope{Instance: "other", Sender: sender, Seq: seq, Payload: []byte(fmt.Sprintf(`{"seq":%d}`, seq))}
+}
+
+// delivered returns the frames queued for the receiver so far
+func delivered(receiver *LobbyUser) []string {
+	var frames []string
+	for {
+		select {
+		case frame := <-receiver.send:
+			frames = append(frames, string(frame))
+		default:
+			return frames
+		}
+	}
+}
+
+func expectFrames(t *testing.T, got []string, seqs ...int64) {
+	t.Helper()
+	var want []string
+	for _, seq := range seqs {
+		want = append(want, fmt.Sprintf(`{"seq":%d}`, seq))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("delivered %v want %v", got, want)
+	}
+}
+
+// Test that messages arriving in order are delivered straight away
+func TestSequencerInOrder(t *testing.T) {
+	sequencer, receiver := sequencerTestLobby(t, time.Hour)
+	for seq := int64(1); seq <= 3; seq++ {
+		sequencer.push(sequencedEnvelope(seq, ""))
+	}
+	expectFrames(t, delivered(receiver), 1, 2, 3)
+	if sequencer.timer != nil {
+		t.Error("reorder timer started without a gap")
+	}
+}
+
+// Test that a message arriving ahead of its predecessor is held until the predecessor shows up
+func TestSequencerHoldsOutOfOrder(t *testing.T) {
+	sequencer, receiver := sequencerTestLobby(t, time.Hour)
+	sequencer.push(sequencedEnvelope(2, ""))
+	sequencer.push(sequencedEnvelope(3, ""))
+	expectFrames(t, delivered(receiver))
+
+	sequencer.push(sequencedEnvelope(1, ""))
+	expectFrames(t, delivered(receiver), 1, 2, 3)
+	if sequencer.timer != nil || len(sequencer.pending) != 0 {
+		t.Error("sequencer still waiting after the gap filled")
+	}
+
+	// unsequenced frames and stragglers older than the gap aren't held
+	sequencer.push(fanoutEnvelope{Payload: []byte(`{"seq":0}`)})
+	sequencer.push(sequencedEnvelope(2, ""))
+	expectFrames(t, delivered(receiver), 0, 2)
+}
+
+// Test that a missing message is given up on after redis.reorder_wait, and what's behind it is delivered
+func TestSequencerSkipsGap(t *testing.T) {
+	sequencer, receiver := sequencerTestLobby(t, 20*time.Millisecond)
+	sequencer.push(sequencedEnvelope(1, ""))
+	sequencer.push(sequencedEnvelope(3, ""))
+	sequencer.push(sequencedEnvelope(5, ""))
+	expectFrames(t, delivered(receiver), 1)
+
+	// 2 never arrives: 3 goes out after one wait, then 4 is missing too and 5 goes out after another
+	deadline := time.Now().Add(time.Second)
+	var got []string
+	for len(got) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		got = append(got, delivered(receiver)...)
+	}
+	expectFrames(t, got, 3, 5)
+
+	sequencer.push(sequencedEnvelope(6, ""))
+	expectFrames(t, delivered(receiver), 6)
+}
+
+// Test that the sender's own message isn't echoed back to it, but still advances the sequence
+func TestSequencerSkipsSenderEcho(t *testing.T) {
+	sequencer, receiver := sequencerTestLobby(t, time.Hour)
+	sequencer.push(sequencedEnvelope(1, "receiver"))
+	sequencer.push(sequencedEnvelope(2, "someone else"))
+	expectFrames(t, delivered(receiver), 2)
+	if sequencer.next != 3 {
+		t.Errorf("next is %d want 3", sequencer.next)
+	}
+}
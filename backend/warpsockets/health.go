@@ -0,0 +1,133 @@
+/* Probes for load balancers and orchestrators: liveness, readiness and a JSON status summary */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Version is reported by /status. Set it at build time with
+// -ldflags "-X github.com/gschussler/word-roulette_go/backend/warpsockets.Version=v1.2.3",
+// otherwise the VCS revision is used when known.
+var Version = "dev"
+
+// how long /readyz waits for Redis before reporting not ready
+const readyTimeout = time.Second
+
+// Served on /status.
+type Status struct {
+	Version     string       `json:"version"`
+	Instance    string       `json:"instance"`
+	Started     time.Time    `json:"started"`
+	Uptime      string       `json:"uptime"`
+	Ready       bool         `json:"ready"`
+	Draining    bool         `json:"draining"`
+	Maintenance bool         `json:"maintenance"`
+	Connections int          `json:"connections"`
+	Lobbies     int          `json:"lobbies"`
+	Config      StatusConfig `json:"config"`
+}
+
+// Settings worth knowing at a glance. Secrets are never included.
+type StatusConfig struct {
+	Fanout         bool   `json:"fanout"`
+	PersistLobbies bool   `json:"persistLobbies"`
+	RenderMessages bool   `json:"renderMessages"`
+	HistoryMaxLen  int64  `json:"historyMaxLen"`
+	KeyPrefix      string `json:"keyPrefix"`
+	LogLevel       string `json:"logLevel"`
+}
+
+// handleHealthz reports that the process is alive. it never touches Redis, so a Redis outage doesn't get the
+// instance restarted
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the instance should get traffic: it has started, isn't draining and can reach Redis
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if reason := s.notReadyReason(r.Context()); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(reason + "\n"))
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+// notReadyReason returns why the instance isn't ready, or "" if it is
+func (s *Server) notReadyReason(ctx context.Context) string {
+	switch {
+	case s.draining.Load():
+		return "draining"
+	case s.started.Load() == nil:
+		return "not started"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	if err := s.redisClient.Ping(ctx).Err(); err != nil {
+		s.logger.Warn("readiness check failed", "err", err)
+		return "redis unreachable"
+	}
+	return ""
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	connections, lobbies := s.localCounts()
+	status := Status{
+		Version:     version(),
+		Instance:    s.instanceID,
+		Ready:       s.notReadyReason(r.Context()) == "",
+		Draining:    s.draining.Load(),
+		Maintenance: s.Maintenance().Enabled,
+		Connections: connections,
+		Lobbies:     lobbies,
+		Config: StatusConfig{
+			Fanout:         s.cfg.Fanout,
+			PersistLobbies: s.cfg.PersistLobbies,
+			RenderMessages: s.cfg.RenderMessages,
+			HistoryMaxLen:  s.cfg.HistoryMaxLen,
+			KeyPrefix:      s.cfg.KeyPrefix,
+			LogLevel:       s.logLevel.Level().String(),
+		},
+	}
+	if started := s.started.Load(); started != nil {
+		status.Started = *started
+		status.Uptime = time.Since(*started).Round(time.Second).String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// localCounts returns the connections and lobbies (with at least one connection) this instance holds
+func (s *Server) localCounts() (connections, lobbies int) {
+	s.lobbyConnections.Range(func(key, value interface{}) bool {
+		if n := len(value.([]*LobbyUser)); n > 0 {
+			connections += n
+			lobbies++
+		}
+		return true
+	})
+	return connections, lobbies
+}
+
+// version falls back to the commit the binary was built from
+func version() string {
+	if Version != "dev" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return Version + "-" + setting.Value
+			}
+		}
+	}
+	return Version
+}
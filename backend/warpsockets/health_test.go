@@ -0,0 +1,81 @@
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test that liveness never depends on Redis while readiness reports why the instance can't take traffic
+func TestHealthProbes(t *testing.T) {
+	s := newTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(path string) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz: got %d, want %d", code, http.StatusOK)
+	}
+
+	// not started yet
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before Start: got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if reason := s.notReadyReason(context.Background()); reason != "not started" {
+		t.Errorf("got reason %q, want %q", reason, "not started")
+	}
+
+	// started, but Redis is down
+	now := time.Now()
+	s.started.Store(&now)
+	if reason := s.notReadyReason(context.Background()); reason != "redis unreachable" {
+		t.Errorf("got reason %q, want %q", reason, "redis unreachable")
+	}
+
+	s.draining.Store(true)
+	if reason := s.notReadyReason(context.Background()); reason != "draining" {
+		t.Errorf("got reason %q, want %q", reason, "draining")
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz while draining: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+// Test that /status reports counts and config without secrets
+func TestStatus(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.RedisPassword = "hunter2"
+	}, WithInstanceID("status-test"))
+	s.lobbyConnections.Store("one", []*LobbyUser{{User: "a"}, {User: "b"}})
+	s.lobbyConnections.Store("empty", []*LobbyUser{})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/status")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if status.Instance != "status-test" || status.Connections != 2 || status.Lobbies != 1 || status.Ready {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if status.Config.HistoryMaxLen != s.cfg.HistoryMaxLen || status.Config.LogLevel != "INFO" {
+		t.Errorf("unexpected config summary: %+v", status.Config)
+	}
+}
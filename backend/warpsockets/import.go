@@ -0,0 +1,118 @@
+/* Transcript import: seeds a new lobby's history from a JSON transcript exported earlier (see export.go) */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// error code returned to the client when a transcript can't be imported
+const CodeImportInvalid = "import_invalid"
+
+// room for one exported message (content, raw content, rendering and the rest of its fields) when sizing the
+// handshake, and for everything in the handshake besides the transcript
+const (
+	maxImportMessageBytes = 4 << 10
+	handshakeOverhead     = 64 << 10
+)
+
+// handshakeReadLimit is the most bytes a WebSocket handshake may take: a transcript of redis.history_max_len
+// messages, as that's all an import keeps. Frames after it are held to maxChatMessageBytes.
+func (s *Server) handshakeReadLimit() int64 {
+	return s.cfg.HistoryMaxLen*maxImportMessageBytes + handshakeOverhead
+}
+
+// validateImport checks a transcript and returns its messages ready to be stored: marked as imported, with colors
+// and rendering redone by this server rather than trusted from the file. System messages are dropped, as nothing
+// in a file can show they came from a server, and arrivals and departures would put people in the roster who
+// aren't there. Like live history, only the newest redis.history_max_len messages are kept. IDs and sequence
+// numbers are assigned by importTranscript.
+func (s *Server) validateImport(transcript *Transcript) ([]Message, *ValidationError) {
+	invalid := func(i int, format string, args ...interface{}) *ValidationError {
+		return &ValidationError{Field: "import", Code: CodeImportInvalid,
+			Msg: fmt.Sprintf("Transcript message %d %s.", i+1, fmt.Sprintf(format, args...))}
+	}
+
+	var messages []Message
+	for i, original := range transcript.Messages {
+		if original.Type[0] != "" {
+			continue
+		}
+		content := original.Content
+		if original.RawContent != "" {
+			content = original.RawContent
+		}
+		if !utf8.ValidString(content) {
+			return nil, invalid(i, "is not valid UTF-8")
+		}
+		if original.Time.IsZero() {
+			return nil, invalid(i, "has no time")
+		}
+		// the same rules as joining, so a transcript can't put words in the System's mouth
+		user, verr := validateName("user", original.User)
+		if verr != nil {
+			return nil, invalid(i, "has an invalid user: %s", verr.Msg)
+		}
+		if content == "" {
+			return nil, invalid(i, "is empty")
+		}
+
+		message := Message{
+			User:          user,
+			Content:       content,
+			Color:         userColor(user),
+			Time:          original.Time,
+			FormattedTime: original.Time.Format("3:04 PM"),
+			Imported:      true,
+		}
+		s.processMessageContent(&message)
+		messages = append(messages, message)
+	}
+	if n := int(s.cfg.HistoryMaxLen); len(messages) > n {
+		messages = messages[len(messages)-n:]
+	}
+	return messages, nil
+}
+
+// importTranscript stores validated messages as the start of a new lobby's history, numbered after anything
+// already in it
+func (s *Server) importTranscript(lobby string, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	last, err := s.redisClient.IncrBy(ctx, s.sequenceKey(lobby), int64(len(messages))).Result()
+	if err != nil {
+		return err
+	}
+	first := last - int64(len(messages)) + 1
+
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range messages {
+			messages[i].ID = generateMessageID()
+			messages[i].Lobby = lobby
+			messages[i].Seq = first + int64(i)
+			messageJSON, err := json.Marshal(messages[i])
+			if err != nil {
+				return err
+			}
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.historyKey(lobby),
+				MaxLen: s.cfg.HistoryMaxLen,
+				Approx: true,
+				Values: map[string]interface{}{"message": messageJSON},
+			})
+		}
+		pipe.HSet(ctx, s.lobbyMetaKey(lobby), "imported", len(messages))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.archiveMessages(lobby, messages...)
+	return nil
+}
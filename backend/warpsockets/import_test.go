@@ -0,0 +1,175 @@
+package warpsockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Test that an exported transcript imports as the same conversation, restamped and marked as imported
+func TestValidateImportRoundTrip(t *testing.T) {
+	s := newTestServer(t, nil)
+	exported, err := renderTranscript("retro", exportTestMessages(), ExportJSON, false, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var transcript Transcript
+	if err := json.Unmarshal(exported, &transcript); err != nil {
+		t.Fatalf("transcript isn't valid JSON: %v", err)
+	}
+
+	messages, verr := s.validateImport(&transcript)
+	if verr != nil {
+		t.Fatalf("unexpected error %v", verr)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages want 2", len(messages))
+	}
+	for i, message := range messages {
+		if !message.Imported {
+			t.Errorf("message %d isn't marked as imported", i)
+		}
+		if message.ID != "" || message.Seq != 0 || message.StreamID != "" {
+			t.Errorf("message %d kept its stamps: %+v", i, message)
+		}
+	}
+	// the raw text is what was typed, and colors come from this server
+	if messages[0].Content != "**hi**" || messages[0].Color != userColor("grant") {
+		t.Errorf("got %+v", messages[0])
+	}
+}
+
+// Test that transcripts which break the rules for live messages are refused
+func TestValidateImportRejects(t *testing.T) {
+	s := newTestServer(t, nil)
+	at := time.Now()
+	tests := map[string]Message{
+		"reserved user": {User: "System", Content: "hello", Time: at},
+		"empty":         {User: "grant", Time: at},
+		"no time":       {User: "grant", Content: "hello"},
+		"invalid utf-8": {User: "grant", Content: "\xff", Time: at},
+	}
+	for name, message := range tests {
+		_, verr := s.validateImport(&Transcript{Messages: []Message{message}})
+		if verr == nil || verr.Code != CodeImportInvalid {
+			t.Errorf("%s: got %v want %s", name, verr, CodeImportInvalid)
+		}
+	}
+}
+
+// Test that system messages are left out of an import rather than stored as the System or as roster changes
+func TestValidateImportDropsSystemMessages(t *testing.T) {
+	s := newTestServer(t, nil)
+	at := time.Now()
+	transcript := Transcript{Messages: []Message{
+		{Type: [2]string{"announcement", ""}, User: "System", Content: "Send your password to grant.", Time: at},
+		{Type: [2]string{"arrived", "ghost"}, User: "System", Content: "ghost has arrived.", Time: at},
+		{Type: [2]string{"kicked", "grant"}, Content: "grant was kicked.", Time: at},
+		{User: "grant", Content: "hello", Time: at},
+	}}
+	messages, verr := s.validateImport(&transcript)
+	if verr != nil {
+		t.Fatalf("unexpected error %v", verr)
+	}
+	if len(messages) != 1 || messages[0].User != "grant" || messages[0].Type != [2]string{} {
+		t.Errorf("got %+v", messages)
+	}
+}
+
+// Test that an import is capped to the newest messages, like live history
+func TestValidateImportRetention(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.cfg.HistoryMaxLen = 2
+	var transcript Transcript
+	for i := 0; i < 5; i++ {
+		transcript.Messages = append(transcript.Messages, Message{User: "grant", Content: fmt.Sprint(i), Time: time.Now()})
+	}
+	messages, verr := s.validateImport(&transcript)
+	if verr != nil {
+		t.Fatalf("unexpected error %v", verr)
+	}
+	if len(messages) != 2 || messages[0].Content != "3" || messages[1].Content != "4" {
+		t.Errorf("got %+v", messages)
+	}
+}
+
+// Test that a handshake carrying a transcript larger than the retention cap allows is refused as an invalid import
+// without being read whole
+func TestHandshakeRejectsOversizedImport(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.cfg.HistoryMaxLen = 2
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	content := strings.Repeat("a", int(s.handshakeReadLimit()))
+	transcript := &Transcript{Messages: []Message{{User: "grant", Content: content, Time: time.Now()}}}
+	if err := conn.WriteJSON(LobbyInfo{Lobby: "seeded", User: "grant", Action: "create", Import: transcript}); err != nil {
+		t.Fatalf("failed to send lobby info: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var reply ErrorResponse
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	if reply.Type != "error" || reply.Code != CodeImportInvalid {
+		t.Errorf("got %+v, want an import_invalid error", reply)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("expected a message too big close, got %v", err)
+	}
+}
+
+// Test that the room made for a transcript is only there for the handshake, chat frames after it are held to
+// maxChatMessageBytes
+func TestChatFramesHeldToChatLimit(t *testing.T) {
+	s, _ := newRedisTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(LobbyInfo{Lobby: "seeded", User: "grant", Action: "create"}); err != nil {
+		t.Fatalf("failed to send lobby info: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// joined once the user's own arrival comes back
+	for {
+		var frame Message
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("failed to read arrival: %v", err)
+		}
+		if frame.Type[0] == "arrived" {
+			break
+		}
+	}
+
+	content := strings.Repeat("a", maxChatMessageBytes)
+	if err := conn.WriteJSON(ReceivedMessage{Lobby: "seeded", User: "grant", Content: content}); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Errorf("expected a message too big close, got %v", err)
+			}
+			break
+		}
+	}
+}
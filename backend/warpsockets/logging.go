@@ -0,0 +1,52 @@
+/* Structured logging with log/slog. The level can change while the server runs (see SetLogLevel) */
+package warpsockets
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newLogger builds the server's logger from log.format, writing at or above level
+func newLogger(format string, level *slog.LevelVar) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// parseLogLevel accepts the level names slog uses ("debug", "info", "warn", "error"), in any case
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return level, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Logger returns the server's logger, so an embedding service or the command can log the same way.
+func (s *Server) Logger() *slog.Logger {
+	return s.logger
+}
+
+// SetLogLevel changes the level of the server's logger while it runs. It has no effect on a logger passed in
+// with WithLogger, whose handler decides its own level.
+func (s *Server) SetLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	s.logLevel.Set(level)
+	s.logger.Info("log level changed", "level", level.String())
+	return nil
+}
+
+// contentAttr logs a message's text, or only its size when log.redact_content is set
+func (s *Server) contentAttr(content string) slog.Attr {
+	if s.cfg.LogRedactContent {
+		return slog.String("content", fmt.Sprintf("[redacted %d bytes]", len(content)))
+	}
+	return slog.String("content", content)
+}
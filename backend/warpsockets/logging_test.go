@@ -0,0 +1,45 @@
+package warpsockets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// Test that message contents are left out of logs when redaction is on
+func TestContentRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.LogRedactContent = true
+	}, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	s.logger.Info("message received", s.contentAttr("my secret plans"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON log line, got %q: %v", buf.String(), err)
+	}
+	if strings.Contains(buf.String(), "secret") || line["content"] != "[redacted 15 bytes]" {
+		t.Errorf("content not redacted: %s", buf.String())
+	}
+}
+
+// Test that the log level can be changed while the server runs
+func TestSetLogLevel(t *testing.T) {
+	s := newTestServer(t, nil)
+	if s.logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatalf("debug logging should be off by default")
+	}
+	if err := s.SetLogLevel("DEBUG"); err != nil {
+		t.Fatalf("failed to set level: %v", err)
+	}
+	if !s.logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("debug logging should be on after SetLogLevel")
+	}
+	if err := s.SetLogLevel("loud"); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
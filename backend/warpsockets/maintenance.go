@@ -0,0 +1,156 @@
+/* Server-wide banners and maintenance mode. Both are kept in Redis so every instance agrees on them */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sent to every client when the banner changes, and to clients joining while one is set.
+// An empty message clears the banner.
+type Banner struct {
+	Type    string `json:"type"` // always "banner"
+	Message string `json:"message"`
+}
+
+// Body of PUT /admin/maintenance and reply of GET /admin/maintenance.
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"` // shown to users who are turned away, maintenance.message by default
+}
+
+func (s *Server) bannerKey() string {
+	return s.cfg.KeyPrefix + "banner"
+}
+
+// holds the message users are turned away with, only while maintenance mode is on
+func (s *Server) maintenanceKey() string {
+	return s.cfg.KeyPrefix + "maintenance"
+}
+
+// SetBanner shows message to every connected client on every instance, and to clients who join later,
+// until it's replaced or cleared with an empty message.
+func (s *Server) SetBanner(ctx context.Context, message string) error {
+	var err error
+	if message == "" {
+		err = s.redisClient.Del(ctx, s.bannerKey()).Err()
+	} else {
+		err = s.redisClient.Set(ctx, s.bannerKey(), message, 0).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	frame, err := json.Marshal(Banner{Type: "banner", Message: message})
+	if err != nil {
+		return err
+	}
+	lobbies, err := s.listLobbies(ctx)
+	for _, lobby := range lobbies {
+		s.publishFrame(lobby, frame, 0, "", nil)
+	}
+	s.logger.Info("banner changed", "lobbies", len(lobbies), "banner", message)
+	return err
+}
+
+// sendBanner gives a newly joined client the current banner, if there is one
+func (s *Server) sendBanner(lobbyUser *LobbyUser) {
+	message, err := s.redisClient.Get(context.Background(), s.bannerKey()).Result()
+	if err != nil {
+		if err != redis.Nil {
+			lobbyUser.logger.Error("error loading banner", "err", err)
+		}
+		return
+	}
+	lobbyUser.enqueueJSON(Banner{Type: "banner", Message: message})
+}
+
+// SetMaintenance turns maintenance mode on or off on every instance. While it's on, lobby checks and new sockets
+// are refused with message (maintenance.message when empty), but connected users carry on until the server drains.
+func (s *Server) SetMaintenance(ctx context.Context, enabled bool, message string) error {
+	if !enabled {
+		if err := s.redisClient.Del(ctx, s.maintenanceKey()).Err(); err != nil {
+			return err
+		}
+		s.maintenance.Store(nil)
+		s.logger.Info("maintenance mode off")
+		return nil
+	}
+
+	if message == "" {
+		message = s.cfg.MaintenanceMessage
+	}
+	if err := s.redisClient.Set(ctx, s.maintenanceKey(), message, 0).Err(); err != nil {
+		return err
+	}
+	s.maintenance.Store(&message)
+	s.logger.Info("maintenance mode on", "message", message)
+	return nil
+}
+
+// Maintenance reports whether maintenance mode is on, and the message users are turned away with.
+// Other instances' changes are picked up on the next heartbeat.
+func (s *Server) Maintenance() MaintenanceState {
+	if message := s.maintenance.Load(); message != nil {
+		return MaintenanceState{Enabled: true, Message: *message}
+	}
+	return MaintenanceState{}
+}
+
+// refreshMaintenance picks up maintenance mode changes made through other instances
+func (s *Server) refreshMaintenance(ctx context.Context) {
+	message, err := s.redisClient.Get(ctx, s.maintenanceKey()).Result()
+	switch {
+	case err == redis.Nil:
+		s.maintenance.Store(nil)
+	case err != nil:
+		s.logger.Error("error loading maintenance mode", "err", err)
+	default:
+		s.maintenance.Store(&message)
+	}
+}
+
+func (s *Server) handleAdminSetBanner(w http.ResponseWriter, r *http.Request) {
+	var banner Banner
+	if err := json.NewDecoder(r.Body).Decode(&banner); err != nil || strings.TrimSpace(banner.Message) == "" {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: "A banner needs a message."})
+		return
+	}
+	if err := s.SetBanner(r.Context(), banner.Message); err != nil {
+		s.logger.Error("error setting banner", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to set the banner."})
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{Type: "success", Message: "Banner set."})
+}
+
+func (s *Server) handleAdminClearBanner(w http.ResponseWriter, r *http.Request) {
+	if err := s.SetBanner(r.Context(), ""); err != nil {
+		s.logger.Error("error clearing banner", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to clear the banner."})
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{Type: "success", Message: "Banner cleared."})
+}
+
+func (s *Server) handleAdminGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Maintenance())
+}
+
+func (s *Server) handleAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var state MaintenanceState
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		writeJSON(w, http.StatusBadRequest, Response{Type: "error", Message: "Invalid request body."})
+		return
+	}
+	if err := s.SetMaintenance(r.Context(), state.Enabled, state.Message); err != nil {
+		s.logger.Error("error changing maintenance mode", "err", err)
+		writeJSON(w, http.StatusInternalServerError, Response{Type: "error", Message: "Unable to change maintenance mode."})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Maintenance())
+}
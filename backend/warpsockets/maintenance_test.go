@@ -0,0 +1,112 @@
+package warpsockets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Test that lobby checks are refused during maintenance unless the user is resuming a session, which a made up
+// resume token doesn't count as
+func TestCheckLobbyDuringMaintenance(t *testing.T) {
+	s := newTestServer(t, nil)
+	message := "back in ten minutes"
+	s.maintenance.Store(&message)
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for _, body := range []string{
+		`{"action":"create","user":"grant","lobby":"closed"}`,
+		`{"action":"join","user":"grant","lobby":"closed","resume":"made-up"}`,
+	} {
+		resp, err := http.Post(srv.URL+"/check-lobby", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s: got status %d, want %d", body, resp.StatusCode, http.StatusServiceUnavailable)
+		}
+		var reply Response
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if reply.Code != "maintenance" || reply.Message != message {
+			t.Errorf("%s: got %+v, want code maintenance and message %q", body, reply, message)
+		}
+	}
+}
+
+// Test that new sockets get a typed maintenance error and a try again later close during maintenance, even
+// with a cursor or a resume token that wasn't issued for them
+func TestWebSocketDuringMaintenance(t *testing.T) {
+	s := newTestServer(t, nil)
+	message := "back in ten minutes"
+	s.maintenance.Store(&message)
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for _, info := range []LobbyInfo{
+		{Lobby: "closed", User: "grant", Action: "create"},
+		{Lobby: "closed", User: "grant", Action: "join", Cursor: "1700000000000-0"},
+		{Lobby: "closed", User: "grant", Action: "join", Resume: "made-up"},
+	} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatalf("failed to connect to WebSocket: %v", err)
+		}
+		defer conn.Close()
+
+		if err := conn.WriteJSON(info); err != nil {
+			t.Fatalf("failed to send lobby info: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		var reply ErrorResponse
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("failed to read reply: %v", err)
+		}
+		if reply.Type != "error" || reply.Code != "maintenance" || reply.Message != message {
+			t.Errorf("%+v: got %+v, want a maintenance error", info, reply)
+		}
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+			t.Errorf("%+v: expected a try again later close, got %v", info, err)
+		}
+	}
+}
+
+// Test that the banner and maintenance routes validate their input, and that a failed change leaves maintenance on
+func TestAdminMaintenanceRoutes(t *testing.T) {
+	s, srv := newAdminTestServer(t)
+
+	if code := adminRequest(t, "PUT", srv.URL+"/admin/banner", testAdminToken, `{"message":""}`); code != http.StatusBadRequest {
+		t.Errorf("empty banner: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := adminRequest(t, "PUT", srv.URL+"/admin/maintenance", testAdminToken, `not json`); code != http.StatusBadRequest {
+		t.Errorf("invalid maintenance body: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := adminRequest(t, "GET", srv.URL+"/admin/maintenance", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("maintenance without token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	message := "back in ten minutes"
+	s.maintenance.Store(&message)
+	if state := s.Maintenance(); !state.Enabled || state.Message != message {
+		t.Errorf("got %+v, want maintenance on", state)
+	}
+	// Redis is unreachable, so the change fails and the state is left alone
+	if code := adminRequest(t, "PUT", srv.URL+"/admin/maintenance", testAdminToken, `{"enabled":false}`); code != http.StatusInternalServerError {
+		t.Errorf("got %d, want %d", code, http.StatusInternalServerError)
+	}
+	if !s.Maintenance().Enabled {
+		t.Errorf("maintenance turned off although Redis wasn't updated")
+	}
+}
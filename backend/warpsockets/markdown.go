@@ -0,0 +1,175 @@
+/* Optional server-side message pipeline: sanitizes content and renders a safe markdown subset to HTML */
+package warpsockets
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// limits nesting like **_*a*_** so a crafted message can't make rendering expensive
+const maxMarkdownDepth = 4
+
+// processMessageContent runs the pipeline over a user message in place.
+// RawContent keeps exactly what the user typed (needed for edits), Content becomes the sanitized text and
+// HTML the rendered markdown that clients can display without trusting the raw input.
+func (s *Server) processMessageContent(message *Message) {
+	// when off (messages.render), content is stored and broadcast as sent
+	if !s.cfg.RenderMessages {
+		return
+	}
+	message.RawContent = message.Content
+	message.Content = sanitizeContent(message.Content)
+	message.HTML = renderMarkdown(message.Content)
+}
+
+// sanitizeContent strips control and bidi-override characters and normalizes whitespace.
+// Newlines are kept (at most one blank line in a row), other whitespace runs collapse to a single space.
+func sanitizeContent(content string) string {
+	var b strings.Builder
+	newlines := 0
+	space := false
+	for _, r := range strings.ReplaceAll(content, "\r\n", "\n") {
+		switch {
+		case r == '\n':
+			newlines++
+			space = false
+			if newlines <= 2 {
+				b.WriteRune(r)
+			}
+			continue
+		case isBidiControl(r):
+			continue
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case unicode.IsControl(r):
+			continue
+		}
+		if space && newlines == 0 && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		newlines = 0
+		b.WriteRune(r)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// embeddings, overrides, isolates and directional marks can reorder surrounding text when displayed
+func isBidiControl(r rune) bool {
+	return (r >= 0x202A && r <= 0x202E) || (r >= 0x2066 && r <= 0x2069) || r == 0x200E || r == 0x200F || r == 0x061C
+}
+
+// renderMarkdown renders **bold**, *italics* (or _italics_), `code` and [links](https://...) to HTML.
+// Everything else is escaped, so the output is safe to insert into the page as is.
+func renderMarkdown(text string) string {
+	var b strings.Builder
+	renderInline(&b, text, 0)
+	return b.String()
+}
+
+func renderInline(b *strings.Builder, text string, depth int) {
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_[]()", rune(rest[1])):
+			// escaped markdown character, write it literally
+			b.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			continue
+		case rest[0] == '\n':
+			b.WriteString("<br>")
+			i++
+			continue
+		case rest[0] == '`':
+			// code spans don't nest anything
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:end+1]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**") && depth < maxMarkdownDepth:
+			if inner, ok := delimited(rest, "**"); ok {
+				b.WriteString("<strong>")
+				renderInline(b, inner, depth+1)
+				b.WriteString("</strong>")
+				i += len(inner) + 4
+				continue
+			}
+		case (rest[0] == '*' || rest[0] == '_') && depth < maxMarkdownDepth:
+			if inner, ok := delimited(rest, rest[:1]); ok {
+				b.WriteString("<em>")
+				renderInline(b, inner, depth+1)
+				b.WriteString("</em>")
+				i += len(inner) + 2
+				continue
+			}
+		case rest[0] == '[' && depth < maxMarkdownDepth:
+			if label, href, n, ok := parseLink(rest); ok {
+				b.WriteString(`<a href="` + html.EscapeString(href) + `" target="_blank" rel="noopener noreferrer nofollow">`)
+				renderInline(b, label, depth+1)
+				b.WriteString("</a>")
+				i += n
+				continue
+			}
+		}
+
+		// plain text up to the next character that could start markup
+		next := strings.IndexAny(rest[1:], "\\\n`*_[")
+		if next < 0 {
+			next = len(rest) - 1
+		}
+		b.WriteString(html.EscapeString(rest[:next+1]))
+		i += next + 1
+	}
+}
+
+// delimited returns the text between an opening delimiter at the start of s and its closing delimiter.
+// Like markdown, the inner text can't start or end with whitespace ("2 * 3 * 4" stays literal).
+func delimited(s, delim string) (string, bool) {
+	body := s[len(delim):]
+	for start := 0; start < len(body); {
+		end := strings.Index(body[start:], delim)
+		if end < 0 {
+			return "", false
+		}
+		end += start
+		// a single "*" shouldn't be closed by half of a "**"
+		if len(delim) == 1 && strings.HasPrefix(body[end:], delim+delim) {
+			start = end + 2
+			continue
+		}
+		inner := body[:end]
+		if inner == "" || strings.TrimSpace(inner) != inner || strings.Contains(inner, "\n\n") {
+			return "", false
+		}
+		return inner, true
+	}
+	return "", false
+}
+
+// parseLink parses "[label](href)" at the start of s, returning the number of bytes consumed.
+// Only http, https and mailto links are rendered, anything else (javascript:, data:, ...) is left as text.
+func parseLink(s string) (label, href string, n int, ok bool) {
+	closeLabel := strings.Index(s, "](")
+	if closeLabel <= 1 || strings.ContainsAny(s[1:closeLabel], "[\n") {
+		return "", "", 0, false
+	}
+	closeHref := strings.IndexByte(s[closeLabel+2:], ')')
+	if closeHref <= 0 {
+		return "", "", 0, false
+	}
+	href = s[closeLabel+2 : closeLabel+2+closeHref]
+	u, err := url.Parse(href)
+	if err != nil || strings.ContainsAny(href, " \n") {
+		return "", "", 0, false
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto":
+	default:
+		return "", "", 0, false
+	}
+	return s[1:closeLabel], u.String(), closeLabel + 3 + closeHref, true
+}
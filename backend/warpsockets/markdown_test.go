@@ -0,0 +1,46 @@
+package warpsockets
+
+import "testing"
+
+// Test that control/bidi characters are stripped and whitespace is normalized
+func TestSanitizeContent(t *testing.T) {
+	tests := map[string]string{
+		"  hello   world  ":         "hello world",
+		"tab\tseparated":            "tab separated",
+		"bell\x07 and null\x00":     "bell and null",
+		"user\u202etxt.exe":         "usertxt.exe",
+		"line one  \n  line two":    "line one\nline two",
+		"a\n\n\n\n\nb":              "a\n\nb",
+		"windows\r\nline endings":   "windows\nline endings",
+		"\u2066isolated\u2069 text": "isolated text",
+	}
+	for input, want := range tests {
+		if got := sanitizeContent(input); got != want {
+			t.Errorf("sanitizeContent(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// Test the supported markdown subset and that everything else is escaped
+func TestRenderMarkdown(t *testing.T) {
+	tests := map[string]string{
+		"**bold** and *italic*":             "<strong>bold</strong> and <em>italic</em>",
+		"_also italic_":                     "<em>also italic</em>",
+		"*a **b** c*":                       "<em>a <strong>b</strong> c</em>",
+		"run `rm -rf <dir>`":                "run <code>rm -rf &lt;dir&gt;</code>",
+		"`**not bold**`":                    "<code>**not bold**</code>",
+		"2 * 3 * 4":                         "2 * 3 * 4",
+		`\*literal\*`:                       "*literal*",
+		"<script>alert(1)</script>":         "&lt;script&gt;alert(1)&lt;/script&gt;",
+		"[site](https://example.com)":       `<a href="https://example.com" target="_blank" rel="noopener noreferrer nofollow">site</a>`,
+		"[x](javascript:alert(1))":          "[x](javascript:alert(1))",
+		`[x](https://a.com/"onmouseover=")`: `<a href="https://a.com/%22onmouseover=%22" target="_blank" rel="noopener noreferrer nofollow">x</a>`,
+		"line\nbreak":                       "line<br>break",
+		"unclosed **bold":                   "unclosed **bold",
+	}
+	for input, want := range tests {
+		if got := renderMarkdown(input); got != want {
+			t.Errorf("renderMarkdown(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
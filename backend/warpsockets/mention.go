@@ -0,0 +1,105 @@
+/* @username mention detection and per-user mention notifications */
+package warpsockets
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// mentions every other member of the lobby. only moderators may use it
+const hereMention = "here"
+
+// parseMentions returns the members of roster mentioned in content, in roster order and without duplicates.
+// Usernames can contain spaces, so the longest roster name following an "@" wins ("@grant s" over "@grant").
+// The sender is never mentioned, and "@here" expands to the whole roster only when sent by a moderator
+// (here reports whether that happened).
+func parseMentions(content, sender string, moderator bool, roster []string) (mentions []string, here bool) {
+	// longest names first so "grant s" is tried before "grant"
+	names := append([]string(nil), roster...)
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	mentioned := make(map[string]bool)
+	for i := 0; i < len(content); i++ {
+		if content[i] != '@' || (i > 0 && isMentionRune(lastRune(content[:i]))) {
+			continue
+		}
+		after := content[i+1:]
+
+		if moderator && hasMentionPrefix(after, hereMention) {
+			here = true
+			for _, name := range roster {
+				mentioned[name] = true
+			}
+			continue
+		}
+		for _, name := range names {
+			if hasMentionPrefix(after, name) {
+				mentioned[name] = true
+				break
+			}
+		}
+	}
+
+	for _, name := range roster {
+		if mentioned[name] && name != sender {
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions, here
+}
+
+// hasMentionPrefix reports whether s starts with name (case-insensitive) followed by a word boundary
+func hasMentionPrefix(s, name string) bool {
+	if len(s) < len(name) || !strings.EqualFold(s[:len(name)], name) {
+		return false
+	}
+	if len(s) == len(name) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(s[len(name):])
+	return !isMentionRune(r)
+}
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// notifyMentions sends a mention notification to every connection of a mentioned user (on any instance).
+// The message itself is broadcast as usual, this lets clients alert users who are scrolled away or in another tab.
+func (s *Server) notifyMentions(lobby string, message Message, here bool) {
+	if len(message.Mentions) == 0 {
+		return
+	}
+
+	notificationJSON, err := json.Marshal(MentionNotification{
+		Type:      "mention",
+		MessageID: message.ID,
+		Lobby:     lobby,
+		From:      message.User,
+		Content:   message.Content,
+		Here:      here,
+	})
+	if err != nil {
+		s.logger.Error("error serializing mention notification", "lobby", lobby, "err", err)
+		return
+	}
+
+	s.publishFrame(lobby, notificationJSON, 0, "", message.Mentions)
+}
+
+// lobbyRoster returns the usernames in a lobby across every instance
+func (s *Server) lobbyRoster(lobby string) []string {
+	roster, err := s.lobbyMembers(lobby)
+	if err != nil {
+		s.logger.Error("error loading lobby roster", "lobby", lobby, "err", err)
+	}
+	return roster
+}
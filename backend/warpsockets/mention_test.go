@@ -0,0 +1,37 @@
+package warpsockets
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test mention parsing against a lobby roster
+func TestParseMentions(t *testing.T) {
+	roster := []string{"grant", "grant s", "alice", "bob"}
+
+	tests := []struct {
+		name      string
+		content   string
+		sender    string
+		moderator bool
+		want      []string
+		wantHere  bool
+	}{
+		{"single", "hey @alice", "bob", false, []string{"alice"}, false},
+		{"case insensitive", "@ALICE and @Bob", "grant", false, []string{"alice", "bob"}, false},
+		{"longest name wins", "@grant s look", "bob", false, []string{"grant s"}, false},
+		{"word boundary", "@alicea @bob!", "grant", false, []string{"bob"}, false},
+		{"email is not a mention", "mail alice@bob.com", "grant", false, nil, false},
+		{"not in lobby", "@carol", "bob", false, nil, false},
+		{"sender excluded", "@bob @alice", "bob", false, []string{"alice"}, false},
+		{"here needs moderator", "@here", "bob", false, nil, false},
+		{"here from moderator", "@here", "bob", true, []string{"grant", "grant s", "alice"}, true},
+	}
+
+	for _, tt := range tests {
+		got, here := parseMentions(tt.content, tt.sender, tt.moderator, roster)
+		if !reflect.DeepEqual(got, tt.want) || here != tt.wantHere {
+			t.Errorf("%s: got %v (here %v), want %v (here %v)", tt.name, got, here, tt.want, tt.wantHere)
+		}
+	}
+}
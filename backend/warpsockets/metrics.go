@@ -0,0 +1,134 @@
+/* Prometheus metrics, served on /metrics. Each Server has its own registry so embedded instances don't collide */
+package warpsockets
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	messagesReceived prometheus.Counter
+	messagesSent     prometheus.Counter
+	broadcastLatency prometheus.Histogram
+	redisLatency     *prometheus.HistogramVec
+	redisErrors      *prometheus.CounterVec
+	slowConsumers    prometheus.Counter
+	upgradeFailures  prometheus.Counter
+}
+
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "warpsockets_messages_received_total",
+			Help: "Chat messages received from clients.",
+		}),
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "warpsockets_messages_sent_total",
+			Help: "Frames queued for delivery to this instance's clients.",
+		}),
+		broadcastLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "warpsockets_broadcast_duration_seconds",
+			Help:    "Time to fan a broadcast out, to every local queue or to Redis when fan-out is enabled.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8), // 100µs to ~1.6s
+		}),
+		redisLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "warpsockets_redis_operation_duration_seconds",
+			Help:    "Latency of Redis commands, by command (pipelines and transactions count as one operation).",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"operation"}),
+		redisErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "warpsockets_redis_errors_total",
+			Help: "Redis commands that failed, by command. Missing keys don't count.",
+		}, []string{"operation"}),
+		slowConsumers: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "warpsockets_slow_consumers_dropped_total",
+			Help: "Connections closed because their write queue filled up.",
+		}),
+		upgradeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "warpsockets_upgrade_failures_total",
+			Help: "Failed WebSocket upgrade attempts, including retries.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.messagesReceived,
+		m.messagesSent,
+		m.broadcastLatency,
+		m.redisLatency,
+		m.redisErrors,
+		m.slowConsumers,
+		m.upgradeFailures,
+		// read from the connection map when scraped, so they can't drift from it
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "warpsockets_connections_active",
+			Help: "WebSocket connections open on this instance.",
+		}, func() float64 {
+			connections, _ := s.localCounts()
+			return float64(connections)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "warpsockets_lobbies_active",
+			Help: "Lobbies with at least one connection on this instance.",
+		}, func() float64 {
+			_, lobbies := s.localCounts()
+			return float64(lobbies)
+		}),
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// redisHook times every command the server sends, covering all the Redis functions without touching each one
+type redisHook struct {
+	metrics *metrics
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.metrics.redisErrors.WithLabelValues("dial").Inc()
+		}
+		return conn, err
+	}
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.observe(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.observe("pipeline", start, err)
+		return err
+	}
+}
+
+func (h redisHook) observe(operation string, start time.Time, err error) {
+	h.metrics.redisLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != redis.Nil {
+		h.metrics.redisErrors.WithLabelValues(operation).Inc()
+	}
+}
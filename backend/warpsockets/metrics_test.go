@@ -0,0 +1,89 @@
+package warpsockets
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape fetches /metrics and returns the exposition text
+func scrape(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+// Test that failed upgrades are counted and the connection gauges are exported
+func TestMetricsUpgradeFailures(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.UpgradeRetries = 0
+	})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	// a plain GET can't be upgraded
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	out := scrape(t, srv)
+	for _, want := range []string{
+		"warpsockets_upgrade_failures_total 1",
+		"warpsockets_connections_active 0",
+		"warpsockets_lobbies_active 0",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+// Test that Redis errors are counted by command
+func TestMetricsRedisErrors(t *testing.T) {
+	s := newTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/check-lobby", "application/json", strings.NewReader(`{"action":"create","user":"grant","lobby":"metrics"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status %d without Redis, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+
+	if out := scrape(t, srv); !strings.Contains(out, `warpsockets_redis_errors_total{operation="exists"} 1`) {
+		t.Errorf("expected a failed exists command to be counted, got:\n%s", out)
+	}
+}
+
+// Test that metrics can be turned off
+func TestMetricsDisabled(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.Metrics = false
+	})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected /metrics to be missing, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,90 @@
+/* Cross-module structs */
+package warpsockets
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Chat messages
+type Message struct {
+	ID            string
+	Type          [2]string
+	Lobby         string
+	Seq           int64  // per-lobby order assigned through Redis (see nextSequence)
+	StreamID      string `json:",omitempty"` // ID of the message's history entry, doubles as a reconnect cursor
+	User          string
+	Content       string
+	RawContent    string `json:",omitempty"` // original text when the message pipeline is enabled (see markdown.go)
+	HTML          string `json:",omitempty"` // sanitized markdown rendering of Content
+	Color         string
+	Mentions      []string `json:",omitempty"` // usernames mentioned with @ (see mention.go)
+	Imported      bool     `json:",omitempty"` // seeded from a transcript when the lobby was created (see import.go)
+	Time          time.Time
+	FormattedTime string
+}
+
+// read lobby JSON info sent from the frontend
+type LobbyInfo struct {
+	Lobby  string `json:"lobby"`
+	User   string `json:"user"`
+	Action string `json:"action"`
+	Cursor string `json:"cursor,omitempty"` // StreamID of the last message seen, when reconnecting
+	Resume string `json:"resume,omitempty"` // resume token from before a server restart (see restart.go)
+	Public bool   `json:"public,omitempty"` // list the lobby in the directory, only read when creating it (see directory.go)
+	Topic  string `json:"topic,omitempty"`  // shown in the directory, only read when creating it
+	// most members the lobby may hold, 0 for limits.lobby_members. only read when creating it (see capacity.go)
+	Capacity int  `json:"capacity,omitempty"`
+	Archive  bool `json:"archive,omitempty"` // keep every message on disk (see archive.go), only read when creating it
+	// JSON transcript (see export.go) to seed the lobby's history with, only read when creating it (see import.go)
+	Import *Transcript `json:"import,omitempty"`
+}
+
+type LobbyUser struct {
+	ID          string // unique per connection, used to skip the sender when broadcasting
+	Conn        *websocket.Conn
+	User        string
+	Color       string // assigned by the server on join (see color.go)
+	Moderator   bool   // the user who created the lobby
+	ResumeToken string // lets the client pick its session back up after a server restart
+
+	// write queue, see writequeue.go
+	mu         sync.Mutex
+	closed     bool
+	closeFrame []byte
+	send       chan []byte
+	done       chan struct{}
+	writeWait  time.Duration      // websocket.write_wait
+	dropped    prometheus.Counter // counts this connection if it's dropped for being too slow
+	logger     *slog.Logger       // carries the connection, lobby, user and remote address
+}
+
+// Chat message as sent by a client
+type ReceivedMessage struct {
+	Type    string `json:"type,omitempty"` // set for requests such as "export" (see export.go), empty for chat
+	Lobby   string `json:"lobby"`
+	User    string `json:"user"`
+	Content string `json:"content"`
+	Color   string `json:"color"` // ignored, the server assigns colors so they can't be spoofed
+}
+
+// Represents an error response message.
+type ErrorResponse struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+// Sent to a mentioned user's connections alongside the normal broadcast of the message.
+type MentionNotification struct {
+	Type      string `json:"type"` // always "mention"
+	MessageID string `json:"messageId"`
+	Lobby     string `json:"lobby"`
+	From      string `json:"from"`
+	Content   string `json:"content"`
+	Here      bool   `json:"here,omitempty"` // sent with @here rather than by name
+}
@@ -0,0 +1,66 @@
+package warpsockets
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Test that browser origins are matched against the allowlist
+func TestOriginAllowed(t *testing.T) {
+	s := &Server{allowedOrigins: normalizeOrigins([]string{
+		"https://warpsockets.example.com/",
+		"https://*.example.org",
+		"http://localhost:3000",
+	})}
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"exact match", "https://warpsockets.example.com", true},
+		{"exact match ignores case", "HTTPS://Warpsockets.Example.com", true},
+		{"wildcard subdomain", "https://chat.example.org", true},
+		{"wildcard nested subdomain", "https://a.b.example.org", true},
+		{"bare apex doesn't match wildcard", "https://example.org", false},
+		{"lookalike domain doesn't match wildcard", "https://evilexample.org", false},
+		{"scheme mismatch", "http://warpsockets.example.com", false},
+		{"wildcard scheme mismatch", "http://chat.example.org", false},
+		{"port mismatch", "http://localhost:3001", false},
+		{"port added", "https://warpsockets.example.com:8443", false},
+		{"wildcard port added", "https://chat.example.org:8443", false},
+		{"not listed", "https://example.net", false},
+		{"not an origin", "warpsockets.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := s.originAllowed(tt.origin); got != tt.want {
+			t.Errorf("%s: originAllowed(%q) = %v want %v", tt.name, tt.origin, got, tt.want)
+		}
+	}
+
+	wildcard := &Server{allowedOrigins: normalizeOrigins([]string{"*"})}
+	if !wildcard.originAllowed("https://anywhere.test") {
+		t.Error(`"*" should allow any origin`)
+	}
+	if wildcard.originAllowed("not an origin") {
+		t.Error(`"*" should still reject a malformed origin`)
+	}
+}
+
+// Test that requests without an Origin, and same-origin requests, are let through whatever the allowlist says
+func TestCheckRequestOrigin(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	r := httptest.NewRequest("GET", "http://warpsockets.internal/ws", nil)
+	if !s.checkRequestOrigin(r) {
+		t.Error("request without an Origin was rejected")
+	}
+	r.Header.Set("Origin", "http://warpsockets.internal")
+	if !s.checkRequestOrigin(r) {
+		t.Error("same-origin request was rejected")
+	}
+	r.Header.Set("Origin", "https://attacker.test")
+	if s.checkRequestOrigin(r) {
+		t.Error("request from a disallowed origin was let through")
+	}
+}
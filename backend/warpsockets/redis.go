@@ -0,0 +1,238 @@
+/* Handles Redis db interactions */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/* check the Redis connection and bring stored data up to date */
+func (s *Server) initRedis(ctx context.Context) error {
+	// ping server to check for successful connection
+	pong, err := s.redisClient.Ping(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("connecting to Redis: %w", err)
+	}
+	s.logger.Info("connected to Redis", "reply", pong)
+
+	if s.cfg.MigrateHistory {
+		s.migrateListHistories()
+	}
+	return nil
+}
+
+// lobbyKey builds the key "<prefix>lobby:<name>:<suffix>". every key and channel is namespaced (redis.key_prefix)
+// so the Redis instance can be shared with other apps
+func (s *Server) lobbyKey(lobby, suffix string) string {
+	return s.cfg.KeyPrefix + "lobby:" + lobby + ":" + suffix
+}
+
+// every key a lobby owns, deleted together once it's empty
+var lobbyKeySuffixes = []string{"history", "seq", "members", "meta", "queue", "queue-seen", "linger"}
+
+// lobby:<name>:history is a Redis Stream with one entry per message (the JSON under the "message" field)
+func (s *Server) historyKey(lobby string) string {
+	return s.lobbyKey(lobby, "history")
+}
+
+// pre-Streams history was a list of JSON messages, newest first. older versions didn't namespace keys
+func legacyHistoryKey(lobby string) string {
+	return "lobby:" + lobby + ":messages"
+}
+
+/* stores received messages in Redis db. sets message.StreamID to the entry's ID, which clients use as a cursor */
+func (s *Server) storeMessage(message *Message) {
+	// serialize as JSON before storing in Redis db
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Error("error serializing message", "lobby", message.Lobby, "err", err)
+		return
+	}
+
+	// append serialized message to the lobby's stream, trimming the oldest entries past the retention limit
+	// (redis.history_max_len). trimming is approximate, so a few more may be kept
+	id, err := s.redisClient.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: s.historyKey(message.Lobby),
+		MaxLen: s.cfg.HistoryMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"message": messageJSON},
+	}).Result()
+	if err != nil {
+		s.logger.Error("error storing message in Redis", "lobby", message.Lobby, "message", message.ID, "err", err)
+		return
+	}
+	message.StreamID = id
+	s.archiveMessages(message.Lobby, *message)
+}
+
+func (s *Server) sequenceKey(lobby string) string {
+	return s.lobbyKey(lobby, "seq")
+}
+
+/* assigns the next number in a lobby's message order. keeps broadcasts ordered across instances */
+func (s *Server) nextSequence(lobby string) int64 {
+	seq, err := s.redisClient.Incr(context.Background(), s.sequenceKey(lobby)).Result()
+	if err != nil {
+		s.logger.Error("error assigning message sequence in Redis", "lobby", lobby, "err", err)
+		return 0
+	}
+	return seq
+}
+
+/* Upon entering a lobby, retrieve messages from Redis db */
+func (s *Server) getExistingMessages(lobbyID string) []Message {
+	entries, err := s.redisClient.XRange(context.Background(), s.historyKey(lobbyID), "-", "+").Result()
+	if err != nil {
+		s.logger.Error("error retrieving messages from Redis", "lobby", lobbyID, "err", err)
+		return nil
+	}
+	return s.decodeHistory(entries)
+}
+
+/* Retrieve only the messages stored after cursor (a StreamID the client already has), used when reconnecting */
+func (s *Server) getMessagesSince(lobbyID, cursor string) []Message {
+	streams, err := s.redisClient.XRead(context.Background(), &redis.XReadArgs{
+		Streams: []string{s.historyKey(lobbyID), cursor},
+		Count:   s.cfg.HistoryMaxLen,
+		Block:   -1, // don't wait for new entries
+	}).Result()
+	if err != nil {
+		if err != redis.Nil {
+			s.logger.Error("error retrieving messages from Redis", "lobby", lobbyID, "cursor", cursor, "err", err)
+		}
+		return nil
+	}
+	if len(streams) == 0 {
+		return nil
+	}
+	return s.decodeHistory(streams[0].Messages)
+}
+
+// stream entries are already oldest first, no need to reverse like the old list
+func (s *Server) decodeHistory(entries []redis.XMessage) []Message {
+	var messages []Message
+	for _, entry := range entries {
+		messageJSON, _ := entry.Values["message"].(string)
+
+		var message Message
+		err := json.Unmarshal([]byte(messageJSON), &message)
+		if err != nil {
+			s.logger.Error("error deserializing message", "entry", entry.ID, "err", err)
+			continue
+		}
+		message.StreamID = entry.ID
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// migrateHistoryScript moves one legacy list (KEYS[1], newest first) into a stream (KEYS[2], oldest first) and
+// deletes it, all in one step so instances starting together can't both copy it. ARGV[1] is
+// redis.history_max_len. Returns how many messages were moved.
+var migrateHistoryScript = redis.NewScript(`
+if redis.call("TYPE", KEYS[1]).ok ~= "list" then
+	return 0
+end
+local messages = redis.call("LRANGE", KEYS[1], 0, -1)
+for i = #messages, 1, -1 do
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[1], "*", "message", messages[i])
+end
+redis.call("DEL", KEYS[1])
+return #messages
+`)
+
+// Moves any list-based lobby history left by older versions into streams. Called at startup only when
+// redis.migrate_history is set: the legacy keys aren't namespaced, so they may belong to another app
+func (s *Server) migrateListHistories() {
+	ctx := context.Background()
+	iter := s.redisClient.ScanType(ctx, 0, legacyHistoryKey("*"), 100, "list").Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		lobby := strings.TrimSuffix(strings.TrimPrefix(key, "lobby:"), ":messages")
+
+		moved, err := migrateHistoryScript.Run(ctx, s.redisClient, []string{key, s.historyKey(lobby)}, s.cfg.HistoryMaxLen).Int()
+		if err != nil {
+			s.logger.Error("error migrating lobby history", "lobby", lobby, "err", err)
+			continue
+		}
+		if moved > 0 {
+			s.logger.Info("migrated lobby history to a stream", "lobby", lobby, "messages", moved)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		s.logger.Error("error scanning for legacy lobby history", "err", err)
+	}
+}
+
+/* Cleans up an empty lobby when the last remaining user leaves */
+func (s *Server) deleteEmptyLobbies(lobby string) {
+	// check if lobby is empty or null (likely caused by user leaving before joining a lobby)
+	if lobby == "" {
+		s.logger.Debug("no lobby to clean up")
+		return
+	}
+	// users waiting for a seat get the lobby instead (see capacity.go)
+	if s.hasWaiters(context.Background(), lobby) {
+		s.logger.Debug("kept empty lobby for its queue", "lobby", lobby)
+		return
+	}
+
+	// delete messages, message order and member registry together (including history that was never migrated)
+	var keys []string
+	if s.cfg.MigrateHistory {
+		keys = append(keys, legacyHistoryKey(lobby))
+	}
+	for _, suffix := range lobbyKeySuffixes {
+		keys = append(keys, s.lobbyKey(lobby, suffix))
+	}
+	ctx := context.Background()
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		pipe.SRem(ctx, s.directoryKey(), lobby)
+		pipe.ZRem(ctx, s.lingeringKey(), lobby)
+		return nil
+	})
+	if err != nil {
+		if err.Error() != "redis: client is closed" {
+			s.logger.Error("error deleting keys of empty lobby", "lobby", lobby, "err", err)
+		}
+		return
+	}
+	s.logger.Debug("deleted empty lobby", "lobby", lobby)
+}
+
+/* Release this instance's data in Redis. Called upon server shutdown */
+// Only lobbies this instance hosts are touched: its members are removed and lobbies left empty are deleted.
+// With PERSIST_LOBBIES nothing is deleted so lobbies can be picked back up after a restart (see restart.go).
+func (s *Server) deleteRedisData() error {
+	ctx := context.Background()
+
+	if s.cfg.PersistLobbies {
+		// hand members over to the restart grace window so they can resume their sessions
+		return s.handOffLobbies(ctx)
+	}
+
+	// SSCAN instead of SMEMBERS so a large set doesn't block Redis
+	iter := s.redisClient.SScan(ctx, s.instanceLobbiesKey(s.instanceID), 0, "", 100).Iterator()
+	for iter.Next(ctx) {
+		s.releaseLobby(ctx, iter.Val(), s.instanceID)
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	err := s.redisClient.Del(ctx, s.instanceLobbiesKey(s.instanceID), s.instanceLeaseKey(s.instanceID)).Err()
+	if err != nil {
+		return err
+	}
+	return s.redisClient.SRem(ctx, s.instancesKey(), s.instanceID).Err()
+}
+
+/* close the Redis client once nothing else needs it */
+func (s *Server) closeRedis() error {
+	return s.redisClient.Close()
+}
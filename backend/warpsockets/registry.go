@@ -0,0 +1,308 @@
+/* Cluster-wide lobby membership kept in Redis, with heartbeated per-instance leases */
+package warpsockets
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// lobby:<name>:members is a hash of username -> instance ID holding the user's connection
+func (s *Server) membersKey(lobby string) string {
+	return s.lobbyKey(lobby, "members")
+}
+
+// lobby:<name>:meta is a hash describing the lobby (creator, creation time, public, topic, capacity, archive ID),
+// kept across restarts
+func (s *Server) lobbyMetaKey(lobby string) string {
+	return s.lobbyKey(lobby, "meta")
+}
+
+// lobbies an instance currently has members in, used to clean up after it if it crashes
+func (s *Server) instanceLobbiesKey(id string) string {
+	return s.cfg.KeyPrefix + "instance:" + id + ":lobbies"
+}
+
+func (s *Server) instanceLeaseKey(id string) string {
+	return s.cfg.KeyPrefix + "instance:" + id + ":lease"
+}
+
+// set of every instance that has registered, alive or not
+func (s *Server) instancesKey() string {
+	return s.cfg.KeyPrefix + "instances"
+}
+
+// sorted set of empty lobbies kept for expiry.linger, scored by the unix time they may be deleted
+func (s *Server) lingeringKey() string {
+	return s.cfg.KeyPrefix + "lingering"
+}
+
+// lobby:<name>:linger marks an empty lobby as lingering, so it still exists for lobby checks
+func (s *Server) lobbyLingerKey(lobby string) string {
+	return s.lobbyKey(lobby, "linger")
+}
+
+// take out this instance's lease and keep it alive. an instance that misses heartbeats for redis.lease_ttl is
+// considered dead and its members are expired
+func (s *Server) initRegistry() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopHeartbeat = cancel
+
+	s.heartbeat(ctx)
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.Heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.heartbeat(ctx)
+				s.expireDeadInstances(ctx)
+				s.expireLobbies(ctx)
+				s.deleteLingeringLobbies(ctx)
+			}
+		}
+	}()
+}
+
+// heartbeat renews the lease and (re)registers the instance. An instance that missed its lease once was taken
+// out of the set by whoever expired it, and has to be put back so its members are expired if it dies for good.
+func (s *Server) heartbeat(ctx context.Context) {
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.instanceLeaseKey(s.instanceID), time.Now().Unix(), s.cfg.LeaseTTL)
+		pipe.SAdd(ctx, s.instancesKey(), s.instanceID)
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error renewing instance lease", "instance", s.instanceID, "err", err)
+	}
+	s.refreshMaintenance(ctx)
+}
+
+// lobbyExists reports whether anyone, on any instance, is in the lobby, or it's lingering for them to come back
+func (s *Server) lobbyExists(lobby string) (bool, error) {
+	n, err := s.redisClient.Exists(context.Background(), s.membersKey(lobby), s.lobbyLingerKey(lobby)).Result()
+	return n > 0, err
+}
+
+// returningCreator reports whether user created the lobby and it's lingering, so nobody else has joined since
+// it emptied
+func (s *Server) returningCreator(lobby, user string) bool {
+	ctx := context.Background()
+	var lingering *redis.IntCmd
+	var creator *redis.StringCmd
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		lingering = pipe.Exists(ctx, s.lobbyLingerKey(lobby))
+		creator = pipe.HGet(ctx, s.lobbyMetaKey(lobby), "creator")
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		s.logger.Error("error checking lobby creator", "lobby", lobby, "err", err)
+		return false
+	}
+	return lingering.Val() > 0 && creator.Val() == user
+}
+
+// lobbyMembers returns the usernames in a lobby across all instances
+func (s *Server) lobbyMembers(lobby string) ([]string, error) {
+	return s.redisClient.HKeys(context.Background(), s.membersKey(lobby)).Result()
+}
+
+// nameTaken reports whether a member of the lobby has a name indistinguishable from user's (see sameName)
+func (s *Server) nameTaken(lobby, user string) bool {
+	members, err := s.lobbyMembers(lobby)
+	if err != nil {
+		s.logger.Error("error listing lobby members", "lobby", lobby, "err", err)
+		return false
+	}
+	for _, member := range members {
+		if sameName(member, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLobbyCreated stores the lobby's metadata when its first member creates it, listing it in the directory
+// if the creator made it public
+func (s *Server) recordLobbyCreated(lobby, creator string, info LobbyInfo) {
+	// archived lobbies get an archive of their own, named in the meta (see archiveMessages)
+	archiveID := ""
+	if info.Archive {
+		archiveID = newArchiveID()
+	}
+	ctx := context.Background()
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.lobbyMetaKey(lobby), "creator", creator, "created", time.Now().Unix(), "public", info.Public,
+			"topic", info.Topic, "capacity", info.Capacity, "archive", archiveID)
+		if info.Public {
+			pipe.SAdd(ctx, s.directoryKey(), lobby)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error storing lobby metadata", "lobby", lobby, "err", err)
+	}
+}
+
+// registerMember records that user joined the lobby through this instance
+func (s *Server) registerMember(lobby, user string) {
+	ctx := context.Background()
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.membersKey(lobby), user, s.instanceID)
+		pipe.SAdd(ctx, s.instanceLobbiesKey(s.instanceID), lobby)
+		// someone came back to a lingering lobby
+		pipe.Del(ctx, s.lobbyLingerKey(lobby))
+		pipe.ZRem(ctx, s.lingeringKey(), lobby)
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error registering lobby member", "lobby", lobby, "user", user, "err", err)
+	}
+}
+
+// unregisterMember removes user from the lobby and returns how many members remain cluster-wide.
+// The removal and the count happen in one transaction so two instances can't both decide they emptied it.
+// Returns -1 if Redis couldn't be reached, in which case the lobby should be left alone.
+func (s *Server) unregisterMember(lobby, user string, lastLocal bool) int64 {
+	ctx := context.Background()
+	var remaining *redis.IntCmd
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.membersKey(lobby), user)
+		remaining = pipe.HLen(ctx, s.membersKey(lobby))
+		if lastLocal {
+			pipe.SRem(ctx, s.instanceLobbiesKey(s.instanceID), lobby)
+		}
+		return nil
+	})
+	if err != nil {
+		if err.Error() != "redis: client is closed" {
+			s.logger.Error("error removing lobby member", "lobby", lobby, "user", user, "err", err)
+		}
+		return -1
+	}
+	return remaining.Val()
+}
+
+// expireDeadInstances removes the members of any instance whose lease has run out (it crashed or lost Redis),
+// announcing their departure and cleaning up lobbies they leave empty.
+func (s *Server) expireDeadInstances(ctx context.Context) {
+	instances, err := s.redisClient.SMembers(ctx, s.instancesKey()).Result()
+	if err != nil {
+		s.logger.Error("error listing instances", "err", err)
+		return
+	}
+
+	for _, id := range instances {
+		if id == s.instanceID {
+			continue
+		}
+		alive, err := s.redisClient.Exists(ctx, s.instanceLeaseKey(id)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+		// only the instance that wins the SREM cleans up, so departures aren't announced twice
+		if won, err := s.redisClient.SRem(ctx, s.instancesKey(), id).Result(); err != nil || won == 0 {
+			continue
+		}
+		s.logger.Warn("instance lease expired, removing its members", "instance", id)
+		s.expireInstance(ctx, id)
+	}
+}
+
+func (s *Server) expireInstance(ctx context.Context, id string) {
+	lobbies, err := s.redisClient.SMembers(ctx, s.instanceLobbiesKey(id)).Result()
+	if err != nil {
+		s.logger.Error("error listing lobbies of instance", "instance", id, "err", err)
+		return
+	}
+
+	for _, lobby := range lobbies {
+		s.releaseLobby(ctx, lobby, id)
+	}
+
+	if err := s.redisClient.Del(ctx, s.instanceLobbiesKey(id)).Err(); err != nil {
+		s.logger.Error("error deleting lobbies of instance", "instance", id, "err", err)
+	}
+}
+
+// releaseLobby removes every member an instance holds in the lobby, announcing their departure to whoever
+// remains or deleting the lobby if nobody does.
+func (s *Server) releaseLobby(ctx context.Context, lobby, id string) {
+	members, err := s.redisClient.HGetAll(ctx, s.membersKey(lobby)).Result()
+	if err != nil {
+		s.logger.Error("error listing lobby members", "lobby", lobby, "err", err)
+		return
+	}
+	for user, owner := range members {
+		if owner != id {
+			continue
+		}
+		if remaining := s.unregisterMember(lobby, user, false); remaining == 0 {
+			s.lobbyEmptied(lobby, user)
+		} else if remaining > 0 {
+			systemMessage := s.generateSystemMessage("departed", lobby, user, systemColor)
+			s.storeMessage(&systemMessage)
+			s.broadcastMessage(lobby, systemMessage, "")
+		}
+	}
+}
+
+// lobbyEmptied is called once the last member leaves a lobby. The lobby and its history are kept for
+// expiry.linger so someone can come back to it (after reloading the page, say), then deleted by
+// deleteLingeringLobbies. The last member's departure is stored so history shows them leaving.
+// Expired lobbies, and lobbies closed by an administrator, don't linger.
+func (s *Server) lobbyEmptied(lobby, user string) {
+	ctx := context.Background()
+	if s.cfg.Linger <= 0 {
+		s.deleteEmptyLobbies(lobby)
+		return
+	}
+	if expired, _ := s.redisClient.HGet(ctx, s.lobbyMetaKey(lobby), "expired").Result(); expired != "" {
+		s.deleteEmptyLobbies(lobby)
+		return
+	}
+
+	departure := s.generateSystemMessage("departed", lobby, user, systemColor)
+	s.storeMessage(&departure)
+	deadline := time.Now().Add(s.cfg.Linger)
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.lobbyLingerKey(lobby), deadline.Unix(), 0)
+		pipe.ZAdd(ctx, s.lingeringKey(), redis.Z{Score: float64(deadline.Unix()), Member: lobby})
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error keeping empty lobby, deleting it", "lobby", lobby, "err", err)
+		s.deleteEmptyLobbies(lobby)
+		return
+	}
+	s.logger.Debug("empty lobby lingering", "lobby", lobby, "until", deadline)
+}
+
+// deleteLingeringLobbies deletes lobbies nobody came back to within expiry.linger. Every instance checks on
+// its heartbeat, whichever removes the lobby from the set deletes it.
+func (s *Server) deleteLingeringLobbies(ctx context.Context) {
+	due, err := s.redisClient.ZRangeByScore(ctx, s.lingeringKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		s.logger.Error("error listing lingering lobbies", "err", err)
+		return
+	}
+
+	for _, lobby := range due {
+		if won, err := s.redisClient.ZRem(ctx, s.lingeringKey(), lobby).Result(); err != nil || won == 0 {
+			continue
+		}
+		// someone may have rejoined through a path that didn't clear it
+		if members, err := s.redisClient.HLen(ctx, s.membersKey(lobby)).Result(); err != nil || members > 0 {
+			continue
+		}
+		s.deleteEmptyLobbies(lobby)
+	}
+}
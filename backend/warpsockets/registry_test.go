@@ -0,0 +1,173 @@
+package warpsockets
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// recordingHook records the commands sent to Redis, whether or not they reach it
+type recordingHook struct {
+	mu   sync.Mutex
+	cmds [][]interface{}
+}
+
+func (h *recordingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) { return next(ctx, network, addr) }
+}
+
+func (h *recordingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.record(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (h *recordingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			h.record(cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func (h *recordingHook) record(cmd redis.Cmder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cmds = append(h.cmds, cmd.Args())
+}
+
+func (h *recordingHook) sent(args ...interface{}) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.ContainsFunc(h.cmds, func(cmd []interface{}) bool {
+		return len(cmd) >= len(args) && slices.Equal(cmd[:len(args)], args)
+	})
+}
+
+// Test that every heartbeat puts the instance back in the instance set, not just the first, so an instance
+// expired after missing its lease once is still cleaned up if it dies later
+func TestHeartbeatReregistersInstance(t *testing.T) {
+	s := newTestServer(t, nil)
+	hook := &recordingHook{}
+	s.redisClient.AddHook(hook)
+
+	s.heartbeat(context.Background())
+	if !hook.sent("set", s.instanceLeaseKey(s.instanceID)) {
+		t.Error("heartbeat didn't renew the lease")
+	}
+	if !hook.sent("sadd", s.instancesKey(), s.instanceID) {
+		t.Error("heartbeat didn't re-register the instance")
+	}
+}
+
+// Test that the unprefixed keys of old versions, which may belong to another app sharing Redis, are only
+// touched once redis.migrate_history opts in
+func TestLegacyHistoryOptIn(t *testing.T) {
+	for _, migrate := range []bool{false, true} {
+		s := newTestServer(t, func(cfg *Config) {
+			cfg.MigrateHistory = migrate
+		})
+		hook := &recordingHook{}
+		s.redisClient.AddHook(hook)
+
+		s.deleteEmptyLobbies("room")
+		if got := hook.sent("del", legacyHistoryKey("room")); got != migrate {
+			t.Errorf("migrate_history=%v: legacy history deleted = %v", migrate, got)
+		}
+	}
+}
+
+// Test that a departure takes a sequence number only when it's stored: one never broadcast would hold up the
+// reordering of every instance's subscribers
+func TestLobbyEmptiedSequencesOnlyStoredDepartures(t *testing.T) {
+	s, mr := newRedisTestServer(t, nil)
+
+	mr.HSet(s.lobbyMetaKey("closed"), "expired", ExpiryClosed)
+	s.lobbyEmptied("closed", "grant")
+	if mr.Exists(s.sequenceKey("closed")) {
+		t.Errorf("deleted lobby took a sequence number")
+	}
+
+	s.lobbyEmptied("lingering", "grant")
+	if seq, _ := mr.Get(s.sequenceKey("lingering")); seq != "1" {
+		t.Errorf("got sequence %q, want the departure's 1", seq)
+	}
+}
+
+// Test that an emptied lobby can still be joined during expiry.linger, and is deleted once it's over
+func TestLingeringLobbyRejoinable(t *testing.T) {
+	s, mr := newRedisTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	join := func() int {
+		resp, err := http.Post(srv.URL+"/check-lobby", "application/json", strings.NewReader(`{"action":"join","user":"lee","lobby":"room"}`))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	s.recordLobbyCreated("room", "grant", LobbyInfo{})
+	s.lobbyEmptied("room", "grant")
+	s.deleteLingeringLobbies(context.Background())
+	if status := join(); status != http.StatusOK {
+		t.Errorf("lingering: got %d, want %d", status, http.StatusOK)
+	}
+
+	// the deadline passes
+	mr.ZAdd(s.lingeringKey(), 1, "room")
+	s.deleteLingeringLobbies(context.Background())
+	if status := join(); status != http.StatusNotFound {
+		t.Errorf("after linger: got %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+// Test that the creator coming back to their lingering lobby moderates it again, and nobody else does
+func TestCreatorModeratesLingeringLobby(t *testing.T) {
+	s, _ := newRedisTestServer(t, nil)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for user, moderator := range map[string]bool{"grant": true, "lee": false} {
+		lobby := "room-" + user
+		s.recordLobbyCreated(lobby, "grant", LobbyInfo{})
+		s.lobbyEmptied(lobby, "grant")
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatalf("failed to connect to WebSocket: %v", err)
+		}
+		defer conn.Close()
+		if err := conn.WriteJSON(LobbyInfo{Lobby: lobby, User: user, Action: "join"}); err != nil {
+			t.Fatalf("failed to send lobby info: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// joined once the user's own arrival comes back
+		for {
+			var frame Message
+			if err := conn.ReadJSON(&frame); err != nil {
+				t.Fatalf("%s: failed to read arrival: %v", user, err)
+			}
+			if frame.Type == [2]string{"arrived", user} {
+				break
+			}
+		}
+
+		conns, _ := s.lobbyConnections.Load(lobby)
+		if users := conns.([]*LobbyUser); len(users) != 1 || users[0].Moderator != moderator {
+			t.Errorf("%s: got moderator %v, want %v", user, users[0].Moderator, moderator)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/* Lobby rehydration across server restarts (PERSIST_LOBBIES=true) */
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// members of lobbies hosted by a restarting instance are handed to this pseudo-instance. its lease lasts the
+// grace window, after which the registry expires it like a crashed instance and anyone who didn't resume departs
+const restartOwner = "restarting"
+
+// Sent to each client on join when lobbies persist, so it can resume its session after a restart.
+type SessionInfo struct {
+	Type  string `json:"type"` // always "session"
+	Token string `json:"token"`
+}
+
+// Stored under a resume token while the server restarts.
+type resumeSession struct {
+	Lobby     string `json:"lobby"`
+	User      string `json:"user"`
+	Moderator bool   `json:"moderator"`
+}
+
+func (s *Server) resumeKey(token string) string {
+	return s.cfg.KeyPrefix + "resume:" + token
+}
+
+func generateResumeToken() string {
+	return uuid.New().String()
+}
+
+// sendSessionInfo gives a newly joined client its resume token
+func (s *Server) sendSessionInfo(lobbyUser *LobbyUser) {
+	if !s.cfg.PersistLobbies {
+		return
+	}
+	lobbyUser.enqueueJSON(SessionInfo{Type: "session", Token: lobbyUser.ResumeToken})
+}
+
+// handOffLobbies moves this instance's members to the restart pseudo-instance and saves their resume tokens.
+// Called on shutdown instead of deleting anything when lobbies persist. tokens last redis.resume_grace.
+func (s *Server) handOffLobbies(ctx context.Context) error {
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.lobbyConnections.Range(func(key, value interface{}) bool {
+			lobby := key.(string)
+			for _, lobbyUser := range value.([]*LobbyUser) {
+				sessionJSON, err := json.Marshal(resumeSession{Lobby: lobby, User: lobbyUser.User, Moderator: lobbyUser.Moderator})
+				if err != nil {
+					s.logger.Error("error serializing resume session", "lobby", lobby, "user", lobbyUser.User, "err", err)
+					continue
+				}
+				pipe.HSet(ctx, s.membersKey(lobby), lobbyUser.User, restartOwner)
+				pipe.SAdd(ctx, s.instanceLobbiesKey(restartOwner), lobby)
+				pipe.Set(ctx, s.resumeKey(lobbyUser.ResumeToken), sessionJSON, s.cfg.ResumeGrace)
+			}
+			return true
+		})
+		pipe.SAdd(ctx, s.instancesKey(), restartOwner)
+		pipe.Set(ctx, s.instanceLeaseKey(restartOwner), time.Now().Unix(), s.cfg.ResumeGrace)
+		pipe.Del(ctx, s.instanceLobbiesKey(s.instanceID), s.instanceLeaseKey(s.instanceID))
+		pipe.SRem(ctx, s.instancesKey(), s.instanceID)
+		return nil
+	})
+	return err
+}
+
+// peekResumeToken reports whether token resumes user's session in lobby, without using it up.
+// Lets the lobby check accept a user the registry still lists as a member.
+func (s *Server) peekResumeToken(token, lobby, user string) bool {
+	_, ok := s.lookupResumeToken(token, lobby, user)
+	return ok
+}
+
+// lookupResumeToken returns the session token resumes if it's user's in lobby. The token stays valid until
+// useResumeToken, so a join refused before the user gets their seat back can be retried with it.
+func (s *Server) lookupResumeToken(token, lobby, user string) (resumeSession, bool) {
+	var session resumeSession
+	if token == "" {
+		return session, false
+	}
+
+	sessionJSON, err := s.redisClient.Get(context.Background(), s.resumeKey(token)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			s.logger.Error("error loading resume token", "err", err)
+		}
+		return session, false
+	}
+	if err := json.Unmarshal([]byte(sessionJSON), &session); err != nil {
+		s.logger.Error("error deserializing resume session", "err", err)
+		return session, false
+	}
+	if session.Lobby != lobby || session.User != user {
+		return resumeSession{}, false
+	}
+	return session, true
+}
+
+// useResumeToken deletes a resume token once its user holds their seat again
+func (s *Server) useResumeToken(token string) {
+	if err := s.redisClient.Del(context.Background(), s.resumeKey(token)).Err(); err != nil {
+		s.logger.Error("error deleting used resume token", "err", err)
+	}
+}
@@ -0,0 +1,123 @@
+package warpsockets
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+)
+
+// handedOffServer is a server whose previous run handed grant, moderator of "room", off to the restart
+// pseudo-instance with resume token "grant-token"
+func handedOffServer(t *testing.T) (*Server, *miniredis.Miniredis, *httptest.Server) {
+	t.Helper()
+	s, mr := newRedisTestServer(t, func(cfg *Config) {
+		cfg.PersistLobbies = true
+	})
+	s.lobbyConnections.Store("room", []*LobbyUser{{User: "grant", Moderator: true, ResumeToken: "grant-token"}})
+	if err := s.handOffLobbies(context.Background()); err != nil {
+		t.Fatalf("failed to hand off lobbies: %v", err)
+	}
+	s.lobbyConnections.Delete("room")
+
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return s, mr, srv
+}
+
+// resumeJoin opens a socket with lobbyInfo and returns the first session or error frame it gets back
+func resumeJoin(t *testing.T, srv *httptest.Server, lobbyInfo LobbyInfo) map[string]interface{} {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect to WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.WriteJSON(lobbyInfo); err != nil {
+		t.Fatalf("failed to send lobby info: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("failed to read reply: %v", err)
+		}
+		if frame["type"] == "session" || frame["type"] == "error" {
+			return frame
+		}
+	}
+}
+
+// Test that a valid token gets the user their seat and role back without announcing them, and is used up
+func TestResumeValidToken(t *testing.T) {
+	s, mr, srv := handedOffServer(t)
+
+	frame := resumeJoin(t, srv, LobbyInfo{Lobby: "room", User: "grant", Action: "join", Resume: "grant-token"})
+	if frame["type"] != "session" {
+		t.Fatalf("got %v, want a session", frame)
+	}
+	if owner := mr.HGet(s.membersKey("room"), "grant"); owner != s.instanceID {
+		t.Errorf("seat held by %q, want %q", owner, s.instanceID)
+	}
+	if mr.Exists(s.resumeKey("grant-token")) {
+		t.Errorf("resume token still valid after use")
+	}
+	conns, _ := s.lobbyConnections.Load("room")
+	if users := conns.([]*LobbyUser); len(users) != 1 || !users[0].Moderator {
+		t.Errorf("got %+v, want grant back as moderator", users)
+	}
+	if s.getExistingMessages("room") != nil {
+		t.Errorf("resumed user was announced")
+	}
+}
+
+// Test that a token only resumes its own user's session in its own lobby, and isn't used up by anyone else
+func TestResumeTokenForSomeoneElse(t *testing.T) {
+	for _, lobbyInfo := range []LobbyInfo{
+		{Lobby: "room", User: "lee", Action: "join", Resume: "grant-token"},
+		{Lobby: "other", User: "grant", Action: "join", Resume: "grant-token"},
+	} {
+		s, mr, srv := handedOffServer(t)
+
+		frame := resumeJoin(t, srv, lobbyInfo)
+		if frame["type"] != "session" {
+			t.Fatalf("%s in %s: got %v, want to join as a newcomer", lobbyInfo.User, lobbyInfo.Lobby, frame)
+		}
+		if owner := mr.HGet(s.membersKey("room"), "grant"); owner != restartOwner {
+			t.Errorf("%s in %s: grant's seat held by %q, want it still held for them", lobbyInfo.User, lobbyInfo.Lobby, owner)
+		}
+		if !mr.Exists(s.resumeKey("grant-token")) {
+			t.Errorf("%s in %s: used up grant's token", lobbyInfo.User, lobbyInfo.Lobby)
+		}
+	}
+}
+
+// Test that a token past redis.resume_grace no longer gets the user their held seat
+func TestResumeExpiredToken(t *testing.T) {
+	s, mr, srv := handedOffServer(t)
+	mr.FastForward(s.cfg.ResumeGrace + time.Second)
+
+	frame := resumeJoin(t, srv, LobbyInfo{Lobby: "room", User: "grant", Action: "join", Resume: "grant-token"})
+	if frame["type"] != "error" || frame["code"] != CodeNameTaken {
+		t.Errorf("got %v, want code %s", frame, CodeNameTaken)
+	}
+}
+
+// Test that a join refused before the seat is back leaves the token usable for a retry
+func TestResumeRefusedKeepsToken(t *testing.T) {
+	s, mr, srv := handedOffServer(t)
+	mr.HSet(s.membersKey("room"), "grant", "another-instance")
+
+	frame := resumeJoin(t, srv, LobbyInfo{Lobby: "room", User: "grant", Action: "join", Resume: "grant-token"})
+	if frame["type"] != "error" || frame["code"] != CodeNameTaken {
+		t.Errorf("got %v, want code %s", frame, CodeNameTaken)
+	}
+	if !mr.Exists(s.resumeKey("grant-token")) {
+		t.Errorf("refused join used up the token")
+	}
+}
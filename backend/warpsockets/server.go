@@ -0,0 +1,235 @@
+/* Embeddable warpsockets server. Everything an instance needs hangs off Server, so several can run in one process */
+package warpsockets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Server is a warpsockets instance: the lobby check API, the WebSocket endpoint and the state behind them.
+// Build one with New, call Start, then serve Handler (or call ListenAndServe) until Shutdown.
+type Server struct {
+	cfg Config
+
+	redisClient *redis.Client
+	ownsRedis   bool // created by New rather than passed in, so Shutdown closes it
+
+	// identifies this server instance to other replicas
+	instanceID     string
+	allowedOrigins []string
+	upgrader       websocket.Upgrader
+	handler        http.Handler
+	metrics        *metrics
+	logger         *slog.Logger
+	logLevel       slog.LevelVar // log.level, adjustable with SetLogLevel
+
+	// lobby -> []*LobbyUser, the connections this instance holds
+	lobbyConnections sync.Map // REPLACING map with sync.Map -> https://pkg.go.dev/sync#Map
+
+	// single subscription connection shared by every lobby this instance hosts
+	fanoutPubSub *redis.PubSub
+	// lobby -> *lobbySequencer, only for lobbies with local connections
+	lobbySequencers sync.Map
+
+	// stops the heartbeat loop on shutdown
+	stopHeartbeat context.CancelFunc
+	// archived lobbies' messages are also written here, nil while archive.dir is unset (see archive.go)
+	archive     MessageStore
+	ownsArchive bool // opened by New rather than passed in, so Shutdown closes it
+	// lobby -> expiry deadline its local members were last warned of (see expiry.go)
+	expiryWarned sync.Map
+	// message users are turned away with while maintenance mode is on, nil otherwise (see maintenance.go)
+	maintenance atomic.Pointer[string]
+	// set once shutdown starts. new upgrades are refused and departures are no longer written to Redis
+	draining atomic.Bool
+	// when Start finished, nil until then (see health.go)
+	started atomic.Pointer[time.Time]
+	// set by ListenAndServe so Shutdown can stop it
+	httpServer atomic.Pointer[http.Server]
+}
+
+// Option customizes a Server in New.
+type Option func(*Server)
+
+// WithRedisClient uses an existing Redis client instead of connecting to redis.host and redis.port.
+// The client is left open on Shutdown. A hook timing its commands for /metrics is added to it.
+func WithRedisClient(client *redis.Client) Option {
+	return func(s *Server) {
+		s.redisClient = client
+	}
+}
+
+// WithInstanceID sets the ID the server registers with in Redis (a random UUID by default).
+// IDs must be unique among the replicas sharing a Redis key prefix.
+func WithInstanceID(id string) Option {
+	return func(s *Server) {
+		s.instanceID = id
+	}
+}
+
+// WithLogger sends the server's logs to logger instead of stderr. log.level and log.format are ignored.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// New builds a server from cfg. Nothing connects or starts until Start.
+func New(cfg Config, opts ...Option) (*Server, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:            cfg,
+		instanceID:     uuid.New().String(),
+		allowedOrigins: normalizeOrigins(cfg.AllowedOrigins),
+	}
+	level, _ := parseLogLevel(cfg.LogLevel) // checked by validate
+	s.logLevel.Set(level)
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger == nil {
+		s.logger = newLogger(cfg.LogFormat, &s.logLevel)
+	}
+
+	if s.redisClient == nil {
+		s.redisClient = redis.NewClient(&redis.Options{
+			Addr:     net.JoinHostPort(cfg.RedisHost, strconv.Itoa(cfg.RedisPort)), // port 6379 is redis default port
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+		s.ownsRedis = true
+	}
+
+	if s.archive == nil && cfg.ArchiveDir != "" {
+		archive, err := NewBoltArchive(cfg.ArchiveDir)
+		if err != nil {
+			return nil, fmt.Errorf("opening archive.dir: %w", err)
+		}
+		s.archive = archive
+		s.ownsArchive = true
+	}
+
+	s.metrics = newMetrics(s)
+	s.redisClient.AddHook(redisHook{metrics: s.metrics})
+
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  cfg.ReadBufferSize,
+		WriteBufferSize: cfg.WriteBufferSize,
+		CheckOrigin:     s.checkRequestOrigin, // see origin.go
+	}
+	s.handler = s.routes()
+	return s, nil
+}
+
+// Start connects to Redis and registers the instance so lobby membership is shared with other replicas.
+func (s *Server) Start(ctx context.Context) error {
+	if err := s.initRedis(ctx); err != nil {
+		return err
+	}
+	s.logger.Info("origin allowlist loaded", "origins", s.allowedOrigins)
+
+	s.initRegistry()
+
+	// share broadcasts with other server replicas through Redis Pub/Sub
+	if s.cfg.Fanout {
+		s.initFanout()
+	}
+
+	now := time.Now()
+	s.started.Store(&now)
+	return nil
+}
+
+// Handler serves the lobby check API, /ws, the health probes, the admin API (when admin.token is set), /metrics (unless server.metrics is off) and (when server.static_dir
+// is set) the frontend.
+// Only origins on the allowlist may use the API or open sockets.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// ListenAndServe serves Handler on server.addr. Like http.Server it returns http.ErrServerClosed after Shutdown.
+func (s *Server) ListenAndServe() error {
+	srv := &http.Server{
+		Addr:    s.cfg.Addr,
+		Handler: s.handler,
+	}
+	s.httpServer.Store(srv)
+	s.logger.Info("server started", "addr", s.cfg.Addr)
+	return srv.ListenAndServe()
+}
+
+// Config returns the configuration the server was built with.
+func (s *Server) Config() Config {
+	return s.cfg
+}
+
+// InstanceID returns the ID the server registers with in Redis.
+func (s *Server) InstanceID() string {
+	return s.instanceID
+}
+
+func (s *Server) routes() http.Handler {
+	// handle cors
+	c := handlers.CORS(
+		handlers.AllowedOriginValidator(s.originAllowed),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"}),
+		handlers.AllowedHeaders([]string{"Content-Type"}),
+	)
+
+	router := mux.NewRouter()
+
+	// every request at debug level, to help debug routing problems
+	router.Use(s.logRequests)
+
+	// accept reqs to check lobby existence
+	router.HandleFunc("/check-lobby", s.checkLobbyExist).Methods("POST")
+	// accept reqs to upgrade HTTP to WebSocket connection
+	router.HandleFunc("/ws", s.handleWebSocket)
+	// public lobby directory, as a listing or a live subscription (see directory.go)
+	router.HandleFunc("/lobbies", s.handleDirectory).Methods("GET")
+	router.HandleFunc("/ws/lobbies", s.handleDirectorySocket)
+	// transcript downloads from links sent to lobby members (see export.go)
+	router.HandleFunc("/export/{token}", s.handleExportDownload).Methods("GET")
+	// probes for the load balancer (see health.go)
+	router.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	router.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
+	router.HandleFunc("/status", s.handleStatus).Methods("GET")
+	// operator API, only when a token is configured (see admin.go)
+	if s.cfg.AdminToken != "" {
+		s.adminRoutes(router.PathPrefix("/admin").Subrouter())
+	}
+	// Prometheus scrapes (see metrics.go)
+	if s.cfg.Metrics {
+		router.Handle("/metrics", s.metrics.handler()).Methods("GET")
+	}
+	// serve frontend dir (default path always last to properly expose other routes)
+	if s.cfg.StaticDir != "" {
+		router.PathPrefix("/").Handler(http.FileServer(http.Dir(s.cfg.StaticDir)))
+	}
+
+	return c(s.originMiddleware(router))
+}
+
+// logRequests logs the incoming HTTP requests (at debug level, so it can be switched on with SetLogLevel)
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Debug("received request", "method", r.Method, "uri", r.RequestURI, "remote", r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
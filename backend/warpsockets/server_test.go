@@ -0,0 +1,102 @@
+package warpsockets
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer builds a server that is never started, with Redis pointed at a port nothing listens on so any
+// command fails fast. configure, unless nil, changes the default config first. An archive the server opens is
+// closed when the test ends.
+func newTestServer(t *testing.T, configure func(*Config), opts ...Option) *Server {
+	t.Helper()
+	cfg := DefaultConfig()
+	unreachableRedis(t, &cfg)
+	if configure != nil {
+		configure(&cfg)
+	}
+	s, err := New(cfg, opts...)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	t.Cleanup(func() {
+		if s.ownsArchive {
+			s.archive.Close()
+		}
+	})
+	return s
+}
+
+// unreachableRedis points cfg at a port nothing listens on
+func unreachableRedis(t *testing.T, cfg *Config) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RedisHost = "127.0.0.1"
+	cfg.RedisPort = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+}
+
+// newRedisTestServer is newTestServer backed by an in-memory Redis, for tests of the Lua scripts and of flows
+// that need Redis to answer. The returned miniredis lets the test inspect keys and move its clock.
+func newRedisTestServer(t *testing.T, configure func(*Config), opts ...Option) (*Server, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.RedisHost = mr.Host()
+		cfg.RedisPort = port
+		if configure != nil {
+			configure(cfg)
+		}
+	}, opts...)
+	return s, mr
+}
+
+// Test that an invalid config is rejected before anything is built
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RedisPort = 0
+	if _, err := New(cfg); err == nil {
+		t.Errorf("expected an error for an invalid config")
+	}
+}
+
+// Test that two servers in one process don't share state: draining one leaves the other accepting sockets
+func TestServersAreIndependent(t *testing.T) {
+	first := newTestServer(t, nil, WithInstanceID("first"))
+	second := newTestServer(t, nil, WithInstanceID("second"))
+	first.draining.Store(true)
+
+	firstSrv := httptest.NewServer(first.Handler())
+	defer firstSrv.Close()
+	secondSrv := httptest.NewServer(second.Handler())
+	defer secondSrv.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(firstSrv.URL, "http")+"/ws", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the draining server to refuse the upgrade, got %v", resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(secondSrv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("expected the other server to accept the upgrade: %v", err)
+	}
+	conn.Close()
+
+	if first.InstanceID() == second.InstanceID() {
+		t.Errorf("both servers registered as %s", first.InstanceID())
+	}
+}
@@ -0,0 +1,83 @@
+/* Graceful shutdown: stop accepting sockets, release Redis state, drain every connection, then close the store */
+package warpsockets
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+// Shutdown stops the server: new sockets are refused, the HTTP server started by ListenAndServe (if any) stops,
+// this instance's state in Redis is released and every connection is drained. It returns once it's safe to exit,
+// or when ctx is done (the wait is also capped at server.drain_timeout).
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+
+	// upper bound on the whole shutdown, including flushing every client's write queue
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.DrainTimeout)
+	defer cancel()
+
+	// stop the listener and wait for in-flight HTTP requests. hijacked WebSocket connections aren't tracked by
+	// the server, those are drained below
+	if srv := s.httpServer.Load(); srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.logger.Error("error shutting down HTTP server", "err", err)
+		}
+	}
+
+	// stop heartbeating and receiving broadcasts before touching this instance's data
+	if s.stopHeartbeat != nil {
+		s.stopHeartbeat()
+	}
+	s.closeFanout()
+
+	// release this instance's lobbies while its members are still known (closing their sockets below would
+	// otherwise be treated as users leaving one by one)
+	if err := s.deleteRedisData(); err != nil {
+		s.logger.Error("error deleting Redis data", "err", err)
+	}
+
+	s.drainConnections(ctx)
+
+	if s.ownsArchive {
+		if err := s.archive.Close(); err != nil {
+			s.logger.Error("error closing archive", "err", err)
+		}
+	}
+
+	if !s.ownsRedis {
+		return nil
+	}
+	return s.closeRedis()
+}
+
+// drainConnections sends every client a close frame after whatever is still queued for it, then waits
+// (until ctx is done) for the write queues to flush before closing the sockets
+func (s *Server) drainConnections(ctx context.Context) {
+	code, reason := websocket.CloseGoingAway, "server shutting down"
+	if s.cfg.PersistLobbies {
+		// clients holding a resume token reconnect once the server is back (see restart.go)
+		code, reason = websocket.CloseServiceRestart, "server restarting, reconnect"
+	}
+
+	var users []*LobbyUser
+	s.lobbyConnections.Range(func(key, value interface{}) bool {
+		for _, user := range value.([]*LobbyUser) {
+			user.close(code, reason)
+			users = append(users, user)
+		}
+		return true
+	})
+
+	for _, user := range users {
+		select {
+		case <-user.done:
+		case <-ctx.Done():
+			user.logger.Warn("timed out flushing connection")
+		}
+		if err := user.Conn.Close(); err != nil {
+			user.logger.Error("error closing connection", "err", err)
+		}
+	}
+	s.logger.Info("drained connections", "connections", len(users))
+}
@@ -0,0 +1,131 @@
+package warpsockets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Test that draining flushes queued frames and then sends a going-away close frame with a reason
+func TestDrainConnections(t *testing.T) {
+	s := newTestServer(t, nil)
+	registered := make(chan struct{})
+
+	// stand-in for handleWebSocket that skips the Redis-backed lobby handshake
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		lobbyUser := s.newLobbyUser(generateConnectionID(), conn, "test-user", userColor("test-user"), s.logger)
+		s.lobbyConnections.Store("drain-lobby", []*LobbyUser{lobbyUser})
+		lobbyUser.enqueue([]byte(`{"Content":"queued before shutdown"}`))
+		close(registered)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect to WebSocket: %v", err)
+	}
+	defer conn.Close()
+	<-registered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	s.drainConnections(ctx)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("expected the queued message before the close frame, got %v", err)
+	}
+	if !strings.Contains(string(msg), "queued before shutdown") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok {
+		t.Fatalf("expected a close frame, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "server shutting down" {
+		t.Errorf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// Test that new WebSocket upgrades are refused once the server starts draining
+func TestUpgradeRefusedWhileDraining(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.draining.Store(true)
+
+	srv := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer srv.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err == nil {
+		t.Fatalf("expected the upgrade to fail while draining")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %v", http.StatusServiceUnavailable, resp)
+	}
+}
+
+// Test that releasing this instance on shutdown deletes only its own lobbies' prefixed keys, leaving other
+// instances' lobbies and anything else sharing the Redis database alone
+func TestDeleteRedisDataOnlyOwnKeys(t *testing.T) {
+	s, mr := newRedisTestServer(t, func(cfg *Config) {
+		cfg.Linger = 0
+	})
+	mr.Set("other-app:session", "keep")
+	s.recordLobbyCreated("mine", "grant", LobbyInfo{})
+	s.registerMember("mine", "grant")
+	mr.HSet(s.membersKey("theirs"), "lee", "another-instance")
+	mr.HSet(s.lobbyMetaKey("theirs"), "creator", "lee")
+
+	hook := &recordingHook{}
+	s.redisClient.AddHook(hook)
+	if err := s.deleteRedisData(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if hook.sent("flushdb") || hook.sent("flushall") {
+		t.Errorf("shutdown flushed the database")
+	}
+	hook.mu.Lock()
+	for _, cmd := range hook.cmds {
+		if cmd[0] != "del" && cmd[0] != "unlink" {
+			continue
+		}
+		for _, key := range cmd[1:] {
+			if !strings.HasPrefix(key.(string), s.cfg.KeyPrefix) || strings.Contains(key.(string), "theirs") {
+				t.Errorf("deleted %v", key)
+			}
+		}
+	}
+	hook.mu.Unlock()
+
+	if !mr.Exists("other-app:session") {
+		t.Errorf("deleted another app's key")
+	}
+	if !mr.Exists(s.membersKey("theirs")) || !mr.Exists(s.lobbyMetaKey("theirs")) {
+		t.Errorf("deleted another instance's lobby")
+	}
+	if mr.Exists(s.lobbyMetaKey("mine")) || mr.Exists(s.instanceLobbiesKey(s.instanceID)) {
+		t.Errorf("left this instance's lobby behind: %v", mr.Keys())
+	}
+}
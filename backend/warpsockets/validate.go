@@ -0,0 +1,209 @@
+/* Validation rules for lobby names and usernames, shared by the lobby check and the WebSocket handshake */
+package warpsockets
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// max length of a lobby name or username (matches the limit enforced by the frontend inputs)
+const maxNameLength = 16
+
+// max length of a lobby's directory topic
+const maxTopicLength = 80
+
+// error codes returned to the client when a name fails validation
+const (
+	CodeNameEmpty       = "name_empty"
+	CodeNameTooLong     = "name_too_long"
+	CodeNameInvalidChar = "name_invalid_character"
+	CodeNameReserved    = "name_reserved"
+	CodeNameTaken       = "name_taken"
+	CodeTopicTooLong    = "topic_too_long"
+	CodeTopicInvalid    = "topic_invalid_character"
+)
+
+// names that could be used to impersonate server generated messages (see generateSystemMessage).
+// compared against the confusable skeleton of a name, so keep these lowercase ASCII.
+var reservedNames = map[string]bool{
+	"system":      true,
+	"server":      true,
+	"admin":       true,
+	"moderator":   true,
+	"warpsockets": true,
+	"here":        true,
+	"everyone":    true,
+}
+
+// Describes why a lobby name or username was rejected.
+type ValidationError struct {
+	Field string // "lobby" or "user"
+	Code  string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Msg)
+}
+
+// validateName checks a lobby name or username and returns its normalized form.
+// field is only used to build the error message ("lobby" or "user").
+func validateName(field, name string) (string, *ValidationError) {
+	label := "Username"
+	if field == "lobby" {
+		label = "Lobby name"
+	}
+
+	if !utf8.ValidString(name) {
+		return "", &ValidationError{Field: field, Code: CodeNameInvalidChar, Msg: label + " is not valid UTF-8."}
+	}
+
+	normalized := normalizeName(name)
+	if normalized == "" {
+		return "", &ValidationError{Field: field, Code: CodeNameEmpty, Msg: label + " cannot be empty."}
+	}
+	if nameLength(normalized) > maxNameLength {
+		return "", &ValidationError{Field: field, Code: CodeNameTooLong, Msg: fmt.Sprintf("%s cannot be longer than %d characters.", label, maxNameLength)}
+	}
+
+	if r, ok := disallowedRune(normalized); ok {
+		return "", &ValidationError{Field: field, Code: CodeNameInvalidChar, Msg: fmt.Sprintf("%s contains an invalid character (%U).", label, r)}
+	}
+
+	if reservedNames[nameSkeleton(normalized)] {
+		return "", &ValidationError{Field: field, Code: CodeNameReserved, Msg: fmt.Sprintf(`"%s" is reserved.`, normalized)}
+	}
+
+	return normalized, nil
+}
+
+// validateLobbyInfo validates both the lobby and user of a request, normalizing them in place
+func validateLobbyInfo(lobby, user *string) *ValidationError {
+	l, verr := validateName("lobby", *lobby)
+	if verr != nil {
+		return verr
+	}
+	u, verr := validateName("user", *user)
+	if verr != nil {
+		return verr
+	}
+	*lobby, *user = l, u
+	return nil
+}
+
+// validateLobbyRequest checks everything a lobby check or WebSocket handshake carries besides the transcript
+// (see validateImport), normalizing the lobby, user and topic in place
+func (s *Server) validateLobbyRequest(info *LobbyInfo) *ValidationError {
+	if verr := validateLobbyInfo(&info.Lobby, &info.User); verr != nil {
+		return verr
+	}
+	topic, verr := validateTopic(info.Topic)
+	if verr != nil {
+		return verr
+	}
+	info.Topic = topic
+	if verr := s.validateCapacity(info.Capacity); verr != nil {
+		return verr
+	}
+	return s.validateArchive(info.Archive)
+}
+
+// validateTopic checks a lobby's directory topic and returns its normalized form. Unlike names it may be empty.
+func validateTopic(topic string) (string, *ValidationError) {
+	if !utf8.ValidString(topic) {
+		return "", &ValidationError{Field: "topic", Code: CodeTopicInvalid, Msg: "Topic is not valid UTF-8."}
+	}
+	normalized := normalizeName(topic)
+	if nameLength(normalized) > maxTopicLength {
+		return "", &ValidationError{Field: "topic", Code: CodeTopicTooLong, Msg: fmt.Sprintf("Topic cannot be longer than %d characters.", maxTopicLength)}
+	}
+	if r, ok := disallowedRune(normalized); ok {
+		return "", &ValidationError{Field: "topic", Code: CodeTopicInvalid, Msg: fmt.Sprintf("Topic contains an invalid character (%U).", r)}
+	}
+	return normalized, nil
+}
+
+// normalizeName applies NFKC (composing "e" + U+0301 into "é", folding fullwidth letters and ideographic space to
+// ASCII), then trims and collapses whitespace.
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(norm.NFKC.String(name)), " ")
+}
+
+// most combining marks in a row after a base character. Thai and Indic scripts stack two or three,
+// more is "zalgo" text meant to spill over neighbouring lines
+const maxStackedMarks = 3
+
+// disallowedRune returns the first rune of a normalized name that isn't allowed. Letters, numbers, punctuation,
+// symbols and plain spaces are fine, and so are combining marks that follow one of those (vowel signs, tone marks
+// and accents NFKC has no precomposed form for). Everything else (control, format/bidi, private use, unassigned,
+// marks with nothing to combine with) is rejected.
+func disallowedRune(name string) (rune, bool) {
+	marks := -1 // not after a base character
+	for _, r := range name {
+		switch {
+		case unicode.Is(unicode.M, r):
+			if marks < 0 || marks >= maxStackedMarks {
+				return r, true
+			}
+			marks++
+		case r == ' ':
+			marks = -1
+		case unicode.In(r, unicode.L, unicode.N, unicode.P, unicode.S):
+			marks = 0
+		default:
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// nameLength counts the characters a name shows as, without the marks combined into them
+func nameLength(name string) int {
+	n := 0
+	for _, r := range name {
+		if !unicode.Is(unicode.M, r) {
+			n++
+		}
+	}
+	return n
+}
+
+// homoglyphs that render (nearly) identically to a Latin letter or digit
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'і': 'i', 'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'ѕ': 's', 'т': 't', 'у': 'y', 'х': 'x', 'ү': 'y', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+	'χ': 'x', 'γ': 'y', 'ϲ': 'c', 'ϳ': 'j',
+	// digits and symbols commonly used as letters
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '|': 'l', '$': 's', '@': 'a',
+	// Latin lookalikes
+	'ı': 'i', 'ł': 'l', 'ɑ': 'a', 'ɡ': 'g', 'ℓ': 'l',
+}
+
+// nameSkeleton folds case, homoglyphs and separators so visually similar names compare equal.
+// "Sуstеm" (with Cyrillic letters), "SYSTEM" and "5ystem" all share the skeleton "system".
+func nameSkeleton(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsSpace(r) || r == '_' || r == '-' || r == '.' {
+			continue
+		}
+		if folded, ok := confusables[r]; ok {
+			r = folded
+		}
+		b.WriteRune(r)
+	}
+	// "rn" reads as "m" in most UI fonts
+	return strings.ReplaceAll(b.String(), "rn", "m")
+}
+
+// sameName reports whether two names would be indistinguishable to other users in a lobby
+func sameName(a, b string) bool {
+	return nameSkeleton(a) == nameSkeleton(b)
+}
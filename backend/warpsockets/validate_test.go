@@ -0,0 +1,132 @@
+package warpsockets
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test that lobby names and usernames are normalized or rejected with the expected error code
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     string
+		wantCode string
+	}{
+		{"plain", "grant", "grant", ""},
+		{"trims and collapses spaces", "  my   lobby ", "my lobby", ""},
+		{"fullwidth folded", "ｌｏｂｂｙ", "lobby", ""},
+		{"empty", "   ", "", CodeNameEmpty},
+		{"too long", "abcdefghijklmnopq", "", CodeNameTooLong},
+		{"control character", "bad\x07name", "", CodeNameInvalidChar},
+		{"bidi override", "abc\u202edef", "", CodeNameInvalidChar},
+		{"decomposed accent composed", "Jose\u0301", "José", ""},
+		{"devanagari vowel sign", "अनिल", "अनिल", ""},
+		{"thai tone marks", "สมศักดิ์", "สมศักดิ์", ""},
+		{"marks don't count toward the length", "สมศักดิ์สมศักดิ์", "สมศักดิ์สมศักดิ์", ""},
+		{"leading combining mark", "\u0301abc", "", CodeNameInvalidChar},
+		{"combining mark after space", "ab \u0301c", "", CodeNameInvalidChar},
+		{"stacked marks", "z\u0300\u0301\u0302\u0303\u0304", "", CodeNameInvalidChar},
+		{"reserved", "System", "", CodeNameReserved},
+		{"reserved homoglyph", "Sуstеm", "", CodeNameReserved},
+		{"reserved leetspeak", "5y5tem", "", CodeNameReserved},
+	}
+
+	for _, tt := range tests {
+		got, verr := validateName("user", tt.input)
+		if tt.wantCode != "" {
+			if verr == nil || verr.Code != tt.wantCode {
+				t.Errorf("%s: got error %v, want code %s", tt.name, verr, tt.wantCode)
+			}
+			continue
+		}
+		if verr != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, verr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Test that usernames differing only by case or homoglyphs are treated as the same user
+func TestSameName(t *testing.T) {
+	if !sameName("modern", "modem") {
+		t.Errorf("expected confusable names to match")
+	}
+	if !sameName("alice", "АLICE") {
+		t.Errorf("expected Cyrillic lookalike to match")
+	}
+	if sameName("alice", "bob") {
+		t.Errorf("expected different names not to match")
+	}
+}
+
+// Test that directory topics are normalized, may be empty, and are rejected when too long or unprintable
+func TestValidateTopic(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     string
+		wantCode string
+	}{
+		{"empty", "", "", ""},
+		{"trims and collapses spaces", "  retro   games ", "retro games", ""},
+		{"too long", strings.Repeat("a", maxTopicLength+1), "", CodeTopicTooLong},
+		{"control character", "bad\x07topic", "", CodeTopicInvalid},
+		{"bidi override", "abc\u202edef", "", CodeTopicInvalid},
+	}
+
+	for _, tt := range tests {
+		got, verr := validateTopic(tt.input)
+		if tt.wantCode != "" {
+			if verr == nil || verr.Code != tt.wantCode {
+				t.Errorf("%s: got error %v, want code %s", tt.name, verr, tt.wantCode)
+			}
+			continue
+		}
+		if verr != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, verr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Test that a lobby request is checked field by field, first failure first, and normalized in place
+func TestValidateLobbyRequest(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	tests := []struct {
+		name     string
+		info     LobbyInfo
+		wantCode string
+	}{
+		{"valid", LobbyInfo{Lobby: " Games  Room ", User: "ada", Topic: " retro   games "}, ""},
+		{"bad lobby", LobbyInfo{Lobby: "", User: "ada"}, CodeNameEmpty},
+		{"bad user", LobbyInfo{Lobby: "games", User: "system"}, CodeNameReserved},
+		{"bad topic", LobbyInfo{Lobby: "games", User: "ada", Topic: "bad\x07topic"}, CodeTopicInvalid},
+		{"bad capacity", LobbyInfo{Lobby: "games", User: "ada", Capacity: -1}, CodeCapacityInvalid},
+		{"archive unavailable", LobbyInfo{Lobby: "games", User: "ada", Archive: true}, CodeArchiveUnavailable},
+	}
+
+	for _, tt := range tests {
+		verr := s.validateLobbyRequest(&tt.info)
+		if tt.wantCode != "" {
+			if verr == nil || verr.Code != tt.wantCode {
+				t.Errorf("%s: got error %v, want code %s", tt.name, verr, tt.wantCode)
+			}
+			continue
+		}
+		if verr != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, verr)
+			continue
+		}
+		if tt.info.Lobby != "Games Room" || tt.info.Topic != "retro games" {
+			t.Errorf("%s: not normalized in place, got %+v", tt.name, tt.info)
+		}
+	}
+}
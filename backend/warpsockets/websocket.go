@@ -0,0 +1,543 @@
+// websocket handling
+package warpsockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// // fleshed out close handler function
+// func closeWebSocket(conn *websocket.Conn) error {
+// 	// send close message
+// 	deadline := time.Now().Add(time.Minute)
+// 	err := conn.WriteControl(
+// 		websocket.CloseMessage,
+// 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+// 		deadline,
+// 	)
+// 	if err != nil {
+// 		return err
+// 	}
+
+// 	// set deadline for reading the next message to prevent hanging
+// 	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+// 	if err != nil {
+// 		return err
+// 	}
+
+// 	// read messages until the close message is confirmed to prevent data loss
+// 	for {
+// 		_, _, err = conn.NextReader()
+// 		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+// 			break
+// 		}
+// 		if err != nil {
+// 			break
+// 		}
+// 	}
+// 	// close TCP connection
+// 	err = conn.Close()
+// 	if err != nil {
+// 		return err
+// 	}
+// 	return nil
+// }
+
+// handle WebSocket connections
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// the server is shutting down, new sockets would just be closed again
+	if s.draining.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// every line about this connection carries its ID, and its lobby and user once they're known
+	connID := generateConnectionID()
+	logger := s.logger.With("conn", connID, "remote", r.RemoteAddr)
+
+	retries := 0
+	for {
+		// upgrade http connection to a WebSocket connection using the server's upgrader
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Warn("error upgrading to WebSocket", "err", err, "attempt", retries+1)
+			s.metrics.upgradeFailures.Inc()
+
+			if retries < s.cfg.UpgradeRetries {
+				retries++
+				time.Sleep(s.cfg.UpgradeRetryDelay)
+				continue
+			} else {
+				logger.Error("max retries exceeded, WebSocket connection failed")
+				return
+			}
+		}
+
+		retries = 0
+		// prime handleWebSocket to gracefully close the WebSocket connection no matter the return scenario
+		// also trying to send message along with the closure, could find a better way
+		defer conn.Close()
+		// defer func() {
+		// 	err := closeWebSocket(conn)
+		// 	if err != nil {
+		// 		log.Println("Error closing WebSocket connection: ", err)
+		// 	}
+		// }()
+
+		// import LobbyInfo struct from models.go
+		var lobbyInfo LobbyInfo
+
+		err = s.readHandshake(conn, &lobbyInfo)
+		if errors.Is(err, errHandshakeTooLarge) {
+			logger.Info("rejected WebSocket handshake", "field", "import", "code", CodeImportInvalid, "err", err)
+			conn.WriteJSON(ErrorResponse{Type: "error", Message: "Transcript is too large to import.", Code: CodeImportInvalid})
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "handshake too large"), time.Now().Add(time.Second))
+			return
+		}
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				logger.Info("user left before entering a lobby")
+			} else {
+				logger.Warn("error reading lobby information", "err", err)
+			}
+			// pass the lobby from the client-side WebSocket upgrade message into the deletion function so it is not
+			// improperly referenced during cleanup
+			s.deleteEmptyLobbies(lobbyInfo.Lobby)
+			return
+		}
+
+		// only the handshake may carry a transcript, everything after it is chat
+		conn.SetReadLimit(maxChatMessageBytes)
+
+		// the lobby check already validated these, but the socket can be opened without it
+		if verr := s.validateLobbyRequest(&lobbyInfo); verr != nil {
+			logger.Info("rejected WebSocket handshake", "field", verr.Field, "code", verr.Code, "err", verr)
+			conn.WriteJSON(ErrorResponse{Type: "error", Message: verr.Msg, Code: verr.Code})
+			return
+		}
+		var imported []Message
+		if lobbyInfo.Import != nil {
+			var verr *ValidationError
+			imported, verr = s.validateImport(lobbyInfo.Import)
+			if verr != nil {
+				logger.Info("rejected WebSocket handshake", "field", verr.Field, "code", verr.Code, "err", verr)
+				conn.WriteJSON(ErrorResponse{Type: "error", Message: verr.Msg, Code: verr.Code})
+				return
+			}
+		}
+
+		// during maintenance only users resuming their own session after a restart get back in, existing lobbies
+		// keep working
+		if maintenance := s.Maintenance(); maintenance.Enabled && !s.peekResumeToken(lobbyInfo.Resume, lobbyInfo.Lobby, lobbyInfo.User) {
+			logger.Info("refused WebSocket during maintenance")
+			conn.WriteJSON(ErrorResponse{Type: "error", Message: maintenance.Message, Code: "maintenance"})
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "maintenance"), time.Now().Add(time.Second))
+			return
+		}
+
+		// frequently referenced by the following operations of handleWebSocket
+		lobby := lobbyInfo.Lobby
+		user := lobbyInfo.User
+		color := userColor(user)
+		// action := lobbyInfo.Action
+		logger = logger.With("lobby", lobby, "user", user)
+
+		// from here on every write goes through the user's queue (see writequeue.go)
+		lobbyUser := s.newLobbyUser(connID, conn, user, color, logger)
+		// flush anything still queued and say goodbye before the deferred conn.Close
+		defer func() {
+			lobbyUser.close(websocket.CloseNormalClosure, "")
+			lobbyUser.waitFlushed(s.cfg.WriteWait)
+		}()
+
+		// picking a session back up after a server restart keeps the user's role
+		resumed := false
+		if lobbyInfo.Resume != "" {
+			if session, ok := s.lookupResumeToken(lobbyInfo.Resume, lobby, user); ok {
+				resumed = true
+				lobbyUser.Moderator = session.Moderator
+			} else {
+				logger.Info("invalid or expired resume token")
+			}
+		}
+
+		// nobody is in the lobby on any instance, so this user is creating it (and moderates it)
+		if !resumed {
+			if exists, err := s.lobbyExists(lobby); err == nil && !exists {
+				lobbyUser.Moderator = true
+				s.recordLobbyCreated(lobby, user, lobbyInfo)
+				if lobbyInfo.Import != nil {
+					if err := s.importTranscript(lobby, imported); err != nil {
+						logger.Error("error importing transcript", "err", err)
+					} else {
+						logger.Info("seeded lobby from transcript", "messages", len(imported))
+					}
+				}
+			} else {
+				if lobbyInfo.Import != nil {
+					logger.Info("ignored transcript, lobby already exists")
+				}
+				// the creator coming back to their emptied lobby before it's deleted moderates it again
+				if err == nil && s.returningCreator(lobby, user) {
+					lobbyUser.Moderator = true
+				}
+			}
+		}
+
+		// seats are claimed in Redis so capacity holds across instances. a user queued for one reads through
+		// the queue's reader from then on (see capacity.go)
+		next := func() ([]byte, error) {
+			_, msg, err := conn.ReadMessage()
+			return msg, err
+		}
+		if !resumed && s.instanceFull() {
+			logger.Warn("refused join, instance is at limits.connections")
+			lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Server is full, try again later.", Code: CodeServerFull})
+			return
+		}
+		// the lobby check refuses names too similar to a member's, the seat claim refuses the exact name atomically
+		if !resumed && s.nameTaken(lobby, user) {
+			logger.Info("refused join, name already in the lobby")
+			lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Someone in the lobby already goes by that name.", Code: CodeNameTaken})
+			return
+		}
+		switch s.claimSeat(context.Background(), lobby, user, resumed) {
+		case seatNameTaken:
+			logger.Info("refused join, name already in the lobby")
+			lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Someone in the lobby already goes by that name.", Code: CodeNameTaken})
+			return
+		case seatFull:
+			if !s.cfg.WaitingQueue {
+				logger.Info("refused join to full lobby")
+				lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "Lobby is full.", Code: CodeLobbyFull})
+				return
+			}
+			reads, admitted := s.waitForSeat(lobbyUser, lobby)
+			if !admitted {
+				return
+			}
+			next = func() ([]byte, error) {
+				read := <-reads
+				return read.msg, read.err
+			}
+		}
+		// the seat is held, so the token has done its job
+		if resumed {
+			s.useResumeToken(lobbyInfo.Resume)
+		}
+
+		// check if the lobby exists in the sync.Map
+		if _, exists := s.lobbyConnections.Load(lobby); !exists {
+			// create a new slice for storing connections to that lobby
+			newConnections := make([]*LobbyUser, 0)
+			// store new slice in the sync.Map
+			s.lobbyConnections.Store(lobby, newConnections)
+		}
+
+		// switch action {
+		// case "join":
+		// 	if _, exists := lobbyConnections[lobby]; !exists {
+		// 		// Lobby doesn't exist, reject join request.
+		// 		log.Printf(`"%s" tried to join a lobby that doesn't exist.`, user)
+		// 		conn.WriteJSON(ErrorResponse{Type: "error", Message: "Lobby does not exist."})
+		// 		return
+		// 	}
+		// 	// lobby exists, add user to lobbyConnections[lobby]
+		// 	addUserToLobby(conn, lobby, user)
+		// case "create":
+		// 	if _, exists := lobbyConnections[lobby]; exists {
+		// 		// Lobby already exists, reject create request.
+		// 		log.Printf(`"%s" tried to create a lobby that already exists.`, user)
+		// 		conn.WriteJSON(ErrorResponse{Type: "error", Message: "Lobby already exists."})
+		// 		return
+		// 	}
+		// 	// the lobby doesn't exist yet, so add it as a map entry to lobbyConnections
+		// 	lobbyConnections[lobby] = make([]*websocket.Conn, 0)
+		// 	addUserToLobby(conn, lobby, user)
+		// default:
+		// 	// not set on closing the connection because of an unknown action at this point
+		// 	log.Printf("Unknown action: %s", action)
+		// }
+
+		// associate the client's WebSocket connection id and username with the requested lobby
+		// resumed users never announced their departure, so don't announce their arrival either
+		s.addUserToLobby(lobby, lobbyUser, lobbyInfo.Cursor, !resumed)
+		s.sendSessionInfo(lobbyUser)
+		s.sendBanner(lobbyUser)
+
+		for {
+			// as long as the client's WebSocket connection remains, read a message from the WebSocket when it arrives
+			msg, err := next()
+			if err != nil {
+				logger.Debug("socket read ended", "err", err)
+
+				// remove reference to user connection from the lobby
+				s.removeUserFromLobby(lobby, lobbyUser)
+
+				// shutdown already released this instance's members in Redis (see Shutdown)
+				if s.draining.Load() {
+					return
+				}
+
+				conns, _ := s.lobbyConnections.Load(lobby)
+				if conns == nil {
+					logger.Warn("lobby missing from connection map")
+					return
+				}
+
+				lobbyConns := conns.([]*LobbyUser)
+				lastLocal := len(lobbyConns) == 0
+
+				// other instances may still have users in the lobby
+				remaining := s.unregisterMember(lobby, user, lastLocal)
+
+				if lastLocal {
+					// no local sockets left to deliver this lobby's broadcasts to
+					s.unsubscribeLobby(lobby)
+					s.lobbyConnections.Delete(lobby)
+				}
+
+				// if the lobby is empty after the removal of this user, it lingers for a while before it's deleted
+				if remaining == 0 {
+					s.lobbyEmptied(lobby, user)
+				} else if remaining > 0 {
+					// there are still other users in the lobby, broadcast that this user has left. generated only
+					// now because a sequence number that's never broadcast holds up every instance's reordering
+					systemMessage := s.generateSystemMessage("departed", lobby, user, systemColor)
+					s.storeMessage(&systemMessage)
+					s.broadcastMessage(lobby, systemMessage, "")
+				}
+
+				return
+			}
+
+			// JSON formatting is solid, so this error is unlikely (maybe data corruption could throw this error?)
+			var received ReceivedMessage
+			if err := json.Unmarshal(msg, &received); err != nil {
+				logger.Error("error unmarshaling sent message content", "err", err)
+				// tell the user that aren't responsible for the connection closing caused by returning this error.
+				lobbyUser.enqueueJSON(ErrorResponse{Type: "error", Message: "An internal error caused you to lose connection to your lobby."})
+				return
+			}
+			if received.Type == "export" {
+				s.handleExportRequest(lobby, lobbyUser, msg)
+				continue
+			}
+			s.metrics.messagesReceived.Inc()
+
+			// test if server is receiving messages
+			logger.Debug("message received", s.contentAttr(received.Content))
+
+			// build message from struct to be stored in Redis
+			message := Message{
+				ID:            generateMessageID(),
+				Lobby:         lobby,
+				Seq:           s.nextSequence(lobby),
+				User:          user,
+				Content:       received.Content,
+				Color:         color,
+				Time:          time.Now(),
+				FormattedTime: time.Now().Format("3:04 PM"),
+			}
+
+			// sanitize and render markdown (no-op unless RENDER_MESSAGES is set)
+			s.processMessageContent(&message)
+
+			var here bool
+			message.Mentions, here = parseMentions(message.Content, user, lobbyUser.Moderator, s.lobbyRoster(lobby))
+
+			s.storeMessage(&message)
+
+			s.broadcastMessage(lobby, message, lobbyUser.ID)
+			s.notifyMentions(lobby, message, here)
+		}
+	}
+}
+
+// cursor is the StreamID of the last message a reconnecting client has, only newer history is sent to it
+func (s *Server) addUserToLobby(lobby string, newUser *LobbyUser, cursor string, announce bool) {
+	user := newUser.User
+
+	// load the current list of connections for lobby
+	conns, _ := s.lobbyConnections.Load(lobby)
+	var lobbyUsers []*LobbyUser
+
+	if conns != nil {
+		lobbyUsers = conns.([]*LobbyUser)
+	}
+
+	// first local connection to this lobby, start receiving its broadcasts from other instances
+	if len(lobbyUsers) == 0 {
+		s.subscribeLobby(lobby)
+	}
+
+	// append the new connection
+	lobbyUsers = append(lobbyUsers, newUser)
+
+	// store the updated connections back to the sync.Map
+	s.lobbyConnections.Store(lobby, lobbyUsers)
+	s.registerMember(lobby, user)
+
+	newUser.logger.Info("connected to lobby", "moderator", newUser.Moderator, "local_connections", len(lobbyUsers))
+
+	// retrieve existing messages from Redis
+	var existingMessages []Message
+	if cursor != "" {
+		existingMessages = s.getMessagesSince(lobby, cursor)
+	} else {
+		existingMessages = s.getExistingMessages(lobby)
+	}
+	for _, message := range existingMessages {
+		// send each message to the connected client
+		msgJSON, err := json.Marshal(message)
+		if err != nil {
+			newUser.logger.Error("error serializing existing message", "message", message.ID, "err", err)
+			continue
+		}
+		newUser.enqueue(msgJSON)
+	}
+	newUser.logger.Debug("sent lobby history", "messages", len(existingMessages), "cursor", cursor)
+	if announce {
+		systemMessage := s.generateSystemMessage("arrived", lobby, user, systemColor)
+		s.storeMessage(&systemMessage)
+		s.broadcastMessage(lobby, systemMessage, "")
+	}
+}
+
+func generateMessageID() string {
+	id := uuid.New()
+	return id.String()
+}
+
+func generateConnectionID() string {
+	return uuid.New().String()
+}
+
+func (s *Server) removeUserFromLobby(lobby string, user *LobbyUser) {
+	conns, _ := s.lobbyConnections.Load(lobby)
+	if conns == nil {
+		user.logger.Warn("lobby missing from connection map")
+		return
+	}
+
+	lobbyUsers := conns.([]*LobbyUser)
+
+	// Remove the connection
+	for i, lobbyUser := range lobbyUsers {
+		if lobbyUser == user {
+			lobbyUsers = append(lobbyUsers[:i], lobbyUsers[i+1:]...)
+			user.logger.Info("disconnected from lobby", "local_connections", len(lobbyUsers))
+			break
+		}
+	}
+
+	// Store updated connections back to sync.Map
+	s.lobbyConnections.Store(lobby, lobbyUsers)
+}
+
+// broadcastMessage sends a message to everyone in the lobby except the sender (senderID is empty for system messages).
+// With REDIS_FANOUT enabled this goes through Redis so users connected to other instances receive it too.
+func (s *Server) broadcastMessage(lobby string, message Message, senderID string) {
+	start := time.Now()
+	defer func() { s.metrics.broadcastLatency.Observe(time.Since(start).Seconds()) }()
+
+	// serialize message to JSON
+	msgJSON, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Error("error serializing message to JSON", "lobby", lobby, "message", message.ID, "err", err)
+		return
+	}
+	s.logger.Debug("message broadcast", "lobby", lobby, "message", message.ID, "seq", message.Seq)
+
+	s.publishFrame(lobby, msgJSON, message.Seq, senderID, nil)
+}
+
+// deliverLocal writes a frame to this instance's connections in the lobby, skipping the sender.
+// If to is set, only those users receive it.
+func (s *Server) deliverLocal(lobby string, frame []byte, senderID string, to []string) {
+	// load the connections from the sync.Map
+	conns, ok := s.lobbyConnections.Load(lobby)
+	if !ok {
+		s.logger.Debug("no local connections in lobby", "lobby", lobby)
+		return
+	}
+
+	lobbyUsers := conns.([]*LobbyUser)
+
+	// broadcast a message to all clients (except for the sender) in the specified lobby
+	for _, lobbyUser := range lobbyUsers {
+		if lobbyUser.ID == senderID || (len(to) > 0 && !slices.Contains(to, lobbyUser.User)) {
+			continue
+		}
+		if lobbyUser.enqueue(frame) {
+			s.metrics.messagesSent.Inc()
+		}
+	}
+}
+
+// closeLocal sends frame to this instance's connections in the lobby (only users in to, if set), then
+// disconnects them with reason
+func (s *Server) closeLocal(lobby string, frame []byte, to []string, reason string) {
+	conns, ok := s.lobbyConnections.Load(lobby)
+	if !ok {
+		return
+	}
+
+	for _, lobbyUser := range conns.([]*LobbyUser) {
+		if len(to) > 0 && !slices.Contains(to, lobbyUser.User) {
+			continue
+		}
+		lobbyUser.enqueue(frame)
+		lobbyUser.disconnect(websocket.ClosePolicyViolation, reason)
+		lobbyUser.logger.Info("disconnected by the server", "reason", reason)
+	}
+}
+
+func (s *Server) generateSystemMessage(action, lobby, user, color string) Message {
+	return Message{
+		ID:            generateMessageID(),
+		Type:          [2]string{action, user},
+		Lobby:         lobby,
+		Seq:           s.nextSequence(lobby),
+		User:          "System",
+		Content:       fmt.Sprintf("%s has %s.", user, action),
+		Color:         color,
+		Time:          time.Now(),
+		FormattedTime: time.Now().Format("3:04 PM"),
+	}
+}
+
+// the most bytes a client may send in one frame after the handshake. the client caps messages at 160 characters,
+// this leaves room for escaping and the rest of the frame
+const maxChatMessageBytes = 4 << 10
+
+var errHandshakeTooLarge = errors.New("handshake is larger than its read limit")
+
+// readHandshake reads the lobby info a socket opens with. It's read through a limited reader rather than
+// conn.SetReadLimit, which closes the connection before the client could be told why.
+func (s *Server) readHandshake(conn *websocket.Conn, lobbyInfo *LobbyInfo) error {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return err
+	}
+	limit := s.handshakeReadLimit()
+	handshake, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(handshake)) > limit {
+		return errHandshakeTooLarge
+	}
+	return json.Unmarshal(handshake, lobbyInfo)
+}
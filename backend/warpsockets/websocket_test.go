@@ -1,4 +1,4 @@
-package main
+package warpsockets
 
 import (
 	"encoding/json"
@@ -7,7 +7,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
@@ -49,13 +48,8 @@ import (
 
 // Test if WebSocket upgrade request will succeed
 func TestWebSocketUpgrade(t *testing.T) {
-	// Set up router and handlers as in main.go
-	router := mux.NewRouter()
-	// router.Use(loggingMiddleware)
-	router.HandleFunc("/ws", handleWebSocket)
-
-	// start test HTTP server in a goroutine
-	srv := httptest.NewServer(router)
+	// Serve the same handler an embedding service or main would
+	srv := httptest.NewServer(newTestServer(t, nil).Handler())
 	defer srv.Close()
 
 	// Create HTTP request to '/ws' endpoint with proper headers
@@ -0,0 +1,125 @@
+/* Per-connection write queues. gorilla/websocket allows one concurrent writer, so every frame goes through here */
+package warpsockets
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newLobbyUser wraps a connection with its write queue and starts the writer.
+// The queue fits a full history replay plus websocket.send_queue_slack frames before a client counts as too slow.
+func (s *Server) newLobbyUser(id string, conn *websocket.Conn, user, color string, logger *slog.Logger) *LobbyUser {
+	lobbyUser := &LobbyUser{
+		ID:          id,
+		Conn:        conn,
+		User:        user,
+		Color:       color,
+		ResumeToken: generateResumeToken(),
+		writeWait:   s.cfg.WriteWait,
+		dropped:     s.metrics.slowConsumers,
+		logger:      logger,
+		send:        make(chan []byte, int(s.cfg.HistoryMaxLen)+s.cfg.SendQueueSlack),
+		done:        make(chan struct{}),
+	}
+	go lobbyUser.writePump()
+	return lobbyUser
+}
+
+// enqueue queues a frame for the client. A client that falls a full queue behind is disconnected rather than
+// holding up the rest of the lobby. Returns false if the frame was not queued.
+func (u *LobbyUser) enqueue(frame []byte) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return false
+	}
+	select {
+	case u.send <- frame:
+		return true
+	default:
+		u.logger.Warn("dropping slow connection", "pending", len(u.send))
+		u.closeLocked(websocket.CloseTryAgainLater, "too many pending messages")
+		u.dropped.Inc()
+		go u.closeConnWhenFlushed()
+		return false
+	}
+}
+
+// disconnect closes the connection from the server side once everything queued so far is written.
+// The read loop then handles it like the user leaving.
+func (u *LobbyUser) disconnect(code int, reason string) {
+	u.close(code, reason)
+	go u.closeConnWhenFlushed()
+}
+
+// the read loop only notices once the socket itself is closed
+func (u *LobbyUser) closeConnWhenFlushed() {
+	<-u.done
+	u.Conn.Close()
+}
+
+// close stops accepting frames. Whatever is already queued is still written, followed by a close frame.
+// Wait on u.done to know when that has happened.
+func (u *LobbyUser) close(code int, reason string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closeLocked(code, reason)
+}
+
+func (u *LobbyUser) closeLocked(code int, reason string) {
+	if u.closed {
+		return
+	}
+	u.closed = true
+	u.closeFrame = websocket.FormatCloseMessage(code, reason)
+	close(u.send)
+}
+
+// writePump is the only goroutine writing to the connection
+func (u *LobbyUser) writePump() {
+	defer close(u.done)
+
+	failed := false
+	for frame := range u.send {
+		// keep draining after a failure so enqueue never blocks, the read loop will clean up
+		if failed {
+			continue
+		}
+		u.Conn.SetWriteDeadline(time.Now().Add(u.writeWait))
+		if err := u.Conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			u.logger.Debug("error writing message", "err", err)
+			failed = true
+		}
+	}
+
+	if !failed {
+		err := u.Conn.WriteControl(websocket.CloseMessage, u.closeFrame, time.Now().Add(time.Second))
+		if err != nil && err != websocket.ErrCloseSent {
+			u.logger.Debug("error sending close frame", "err", err)
+		}
+	}
+}
+
+// waitFlushed waits for the writer to finish, giving up after timeout
+func (u *LobbyUser) waitFlushed(timeout time.Duration) bool {
+	select {
+	case <-u.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// enqueueJSON serializes v and queues it for the client
+func (u *LobbyUser) enqueueJSON(v interface{}) bool {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		u.logger.Error("error serializing frame", "err", err)
+		return false
+	}
+	return u.enqueue(frame)
+}
@@ -18,6 +18,14 @@ const App = () => {
   const [user, setUser] = useState('');
   const [userColor, setUserColor] = useState('')
   const [lobby, setLobby] = useState('');
+  // only sent when creating a lobby: whether it's listed in the lobby directory, the topic shown there,
+  // how many members it may hold (0 for the server's limit), whether its messages are archived on the server,
+  // and an exported JSON transcript to seed it with
+  const [isPublic, setIsPublic] = useState(false);
+  const [archive, setArchive] = useState(false);
+  const [topic, setTopic] = useState('');
+  const [capacity, setCapacity] = useState(0);
+  const [transcript, setTranscript] = useState(null);
   const [loading, setLoading] = useState(false);
   const [playDenied] = useSound(Denied, {volume: muted ? 0: 0.03});
   const [playNormal] = useSound(Normal, {volume: muted ? 0: 0.03})
@@ -25,8 +33,13 @@ const App = () => {
   // socket data needs to be accessible by other components through socket.current; Lobby.jsx after the call to join a lobby within Welcome.jsx
   const socket = useRef(null);
   const [socketConnected, setSocketConnected] = useState(false);
+  // the resume token the server hands out on joining (sent back after a server restart to keep the seat and role)
+  // and the StreamID of the newest message received, so a reconnect is only sent what was missed. set by Lobby.jsx
+  const session = useRef({ token: '', cursor: '' });
+  // bumped each time the socket is reopened after an unexpected close, Lobby.jsx rejoins on the new socket
+  const [reconnects, setReconnects] = useState(0);
 
-  const checkLobbyExist = async (action, user, lobby) => {
+  const checkLobbyExist = async (action, user, lobby, resume) => {
     // console.log(action, user, lobby)
     // `https://${process.env.EXT_IP}/check-lobby`
     const checkPath = process.env.NODE_ENV === 'production'
@@ -38,7 +51,7 @@ const App = () => {
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ action, user, lobby }),
+      body: JSON.stringify(action === 'create' ? { action, user, lobby, topic, capacity, archive } : { action, user, lobby, resume: resume || undefined }),
     });
 
     const data = await response.json()
@@ -52,10 +65,10 @@ const App = () => {
     return data;
   };
 
-  const connectWebSocket = async () => {
+  const connectWebSocket = async (checkAction = action, resume = '') => {
     try {
       // check for lobby's existence in db before continuing with WebSocket upgrade
-      await checkLobbyExist(action, user, lobby);
+      await checkLobbyExist(checkAction, user, lobby, resume);
       // async/await syntax not supported by WebSockets, so the ws upgrade itself requires a Promise
       return new Promise((resolve, reject) => {
         if(socket.current && socket.current.readyState === WebSocket.OPEN) {
@@ -73,10 +86,12 @@ const App = () => {
 
         // console.log("Creating new WebSocket connection...")
         socket.current = new WebSocket(wsPath);
+        let opened = false;
 
         socket.current.onopen = (e) => {
           // console.log(`in socket.current.onopen ${action}`)
           // console.log('WebSocket connected');
+          opened = true;
           setSocketConnected(true);
           resolve();
         };
@@ -84,7 +99,14 @@ const App = () => {
         socket.current.onclose = (e) => {
           // console.log('WebSocket closed code: ', e.code)
           // console.log('WebSocket closed');
-          setSocketConnected(false);
+          // the server restarting (1012) or the connection dropping (1006) doesn't end the session, stay in the lobby
+          // and rejoin once the server is reachable again
+          if(opened && (e.code === 1012 || e.code === 1006)) {
+            reconnect();
+          } else if(!reconnecting.current) {
+            session.current = { token: '', cursor: '' };
+            setSocketConnected(false);
+          }
           reject(new Error('WebSocket closed: ', e.code));
         };
       });
@@ -93,6 +115,29 @@ const App = () => {
     }
   };
 
+  // retries with backoff for about as long as the server keeps resume tokens (redis.resume_grace, 2 minutes by default)
+  const reconnecting = useRef(false);
+  const reconnect = async () => {
+    reconnecting.current = true;
+    try {
+      for(let attempt = 0; attempt < 10; attempt++) {
+        await new Promise(resolve => setTimeout(resolve, Math.min(1000 * 2 ** attempt, 15000)));
+        try {
+          await connectWebSocket('join', session.current.token);
+          setReconnects(n => n + 1);
+          return;
+        } catch (error) {
+          // the lobby was deleted in the meantime, there's nothing to go back to
+          if(error.status === 404) break;
+        }
+      }
+      session.current = { token: '', cursor: '' };
+      setSocketConnected(false);
+    } finally {
+      reconnecting.current = false;
+    }
+  };
+
   useEffect(() => {
     // clean up WebSocket connection when the application unmounts
     return () => {
@@ -124,6 +169,16 @@ const App = () => {
             setUser={setUser}
             action={action}
             setAction={setAction}
+            isPublic={isPublic}
+            setIsPublic={setIsPublic}
+            topic={topic}
+            setTopic={setTopic}
+            capacity={capacity}
+            setCapacity={setCapacity}
+            archive={archive}
+            setArchive={setArchive}
+            transcript={transcript}
+            setTranscript={setTranscript}
             muted={muted}
             setMuted={setMuted}
             playDenied={playDenied}
@@ -141,6 +196,14 @@ const App = () => {
               lobby={lobby}
               setLobby={setLobby}
               setUser={setUser}
+              action={action}
+              isPublic={isPublic}
+              topic={topic}
+              capacity={capacity}
+              archive={archive}
+              transcript={transcript}
+              session={session}
+              reconnects={reconnects}
               muted={muted}
               setMuted={setMuted}
               playDenied={playDenied}
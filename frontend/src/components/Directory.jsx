@@ -0,0 +1,91 @@
+/**
+ * Public lobby directory shown on the landing page.
+ * @module Directory
+ */
+
+import React, { useEffect, useRef, useState } from 'react';
+import '../styles/directory.scss';
+
+/**
+ * Lists public lobbies, kept up to date over a WebSocket subscription to the server's directory.
+ * @param {Object} props - Component props.
+ * @param {Function} props.selectLobby - Called with a lobby's name when it's picked from the list.
+ * @returns {JSX.Element} Rendered Directory component.
+ */
+
+const Directory = ({ selectLobby }) => {
+  const [lobbies, setLobbies] = useState([]);
+  const [search, setSearch] = useState('');
+  const [sort, setSort] = useState('popular');
+  const directorySocket = useRef(null);
+
+  useEffect(() => {
+    const directoryPath = process.env.NODE_ENV === 'production'
+      ? `wss://warpsockets.grantschussler.dev/ws/lobbies`
+      : `ws://localhost:8085/ws/lobbies`;
+
+    directorySocket.current = new WebSocket(directoryPath);
+    // the server pushes the whole listing whenever it changes
+    directorySocket.current.onmessage = (e) => {
+      const listing = JSON.parse(e.data);
+      if(listing.type === 'lobbies') {
+        setLobbies(listing.lobbies);
+      }
+    };
+
+    return () => {
+      directorySocket.current.close(1000, "OK - left the landing page");
+    };
+  }, []);
+
+  // ask for a new listing whenever the search or order changes
+  useEffect(() => {
+    const query = JSON.stringify({ prefix: search, sort });
+    if(directorySocket.current.readyState === WebSocket.OPEN) {
+      directorySocket.current.send(query);
+    } else {
+      directorySocket.current.onopen = () => directorySocket.current.send(query);
+    }
+  }, [search, sort]);
+
+  return (
+    <div className='directory'>
+      <div className='directory-h'>
+        <p className='directory-title'>public lobbies</p>
+        <input
+          className='directory-search'
+          value={search}
+          onChange={(e) => setSearch(e.target.value.toLowerCase().trimStart())}
+          placeholder='search'
+          maxLength={20}
+        />
+        <select className='directory-sort' value={sort} onChange={(e) => setSort(e.target.value)}>
+          <option value='popular'>popular</option>
+          <option value='active'>active</option>
+          <option value='name'>name</option>
+        </select>
+      </div>
+      {lobbies.length === 0 ? (
+        <p className='directory-empty'>No public lobbies{search ? ` starting with "${search}"` : ''}.</p>
+      ) : (
+        <ul className='directory-list'>
+          {lobbies.map((entry) => (
+            <li key={entry.name}>
+              <button
+                className='directory-entry'
+                onMouseDown={() => selectLobby(entry.name)}
+                onKeyDown={(e) => {if(e.key === 'Enter') selectLobby(entry.name)}}
+              >
+                <span className='directory-name'>{entry.name}</span>
+                <span className='directory-topic'>{entry.topic}</span>
+                <span className='directory-members'>{entry.members} online</span>
+              </button>
+            </li>
+          ))}
+        </ul>
+      )}
+    </div>
+  );
+};
+
+export default Directory;
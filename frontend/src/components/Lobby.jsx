@@ -24,10 +24,18 @@ import { ExpandingTextarea } from './TextInput.js';
  * @param {Function} props.setLobby - Function to set lobby name state.
  * @param {Function} props.setShowLobby - Function to set lobby visibility.
  * @param {Function} props.setUser - Function to set username state.
+ * @param {string} props.action - 'create' or 'join', a created lobby is sent its directory settings.
+ * @param {boolean} props.isPublic - Whether a created lobby is listed in the lobby directory.
+ * @param {string} props.topic - Topic shown for a created lobby in the lobby directory.
+ * @param {number} props.capacity - Most members a created lobby may hold, 0 for the server's limit.
+ * @param {boolean} props.archive - Whether a created lobby's messages are kept in the server's archive.
+ * @param {Object} props.transcript - Exported JSON transcript a created lobby's history is seeded with, or null.
+ * @param {React.MutableRefObject} props.session - Resume token and cursor (StreamID of the newest message) for rejoining.
+ * @param {number} props.reconnects - How many times the socket was reopened, the lobby is rejoined each time.
  * @returns {JSX.Element} - Rendered Lobby component
  */
 
-const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMuted, playDenied, playNormal }) => {
+const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, action, isPublic, topic, capacity, archive, transcript, session, reconnects, muted, setMuted, playDenied, playNormal }) => {
   const [message, setMessage] = useState('');
   const [messageList, setMessageList] = useState([]);
   const [userList, setUserList] = useState([]);
@@ -35,6 +43,13 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
   const [newMessages, setNewMessages] = useState(false);
   const [disconnected, setDisconnected] = useState(false);
   const [settingsModalOpen, setSettingsModalOpen] = useState(false);
+  const [banner, setBanner] = useState('');
+  // why the server refused or ended the session (lobby full, kicked, maintenance...), or a request failed
+  const [errorMessage, setErrorMessage] = useState('');
+  // latest @mention of this user, shown for a few seconds
+  const [mention, setMention] = useState(null);
+  // place in line while waiting for a seat in a full lobby, 0 once in
+  const [queuePosition, setQueuePosition] = useState(0);
   const [playSend] = useSound(Send, {volume: muted ? 0: 0.05});
   const [playCog] = useSound(Cog, {volume: muted ? 0: 0.02});
   const [playLeave] = useSound(Leave, {volume: muted ? 0: 0.1});
@@ -83,6 +98,13 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
    * @returns {void}
    */
 
+  // ask the server for the lobby's history as a file, see the 'export' frame in handleMessage
+  const exportTranscript = (format, hideSystem) => {
+    if(socket.current.readyState === 1) {
+      socket.current.send(JSON.stringify({ type: 'export', format: format, hideSystem: hideSystem }));
+    }
+  }
+
   const sendMessage = async () => {
     // // captures time of process client-side; used to get duration of sending and receiving a message
     // startTimeRef.current  = performance.now();
@@ -219,9 +241,72 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
       let messageContent = JSON.parse(e.data);
       // console.log(messageContent);
 
+      // operator banners ("maintenance in 10 minutes") stay up until replaced or cleared with an empty message
+      if(messageContent.type === 'banner') {
+        setBanner(messageContent.message);
+        return;
+      }
+
+      // the lobby is about to close (or just did) for inactivity or its maximum lifetime
+      if(messageContent.type === 'expiry_warning' || messageContent.type === 'expired') {
+        setBanner(messageContent.message);
+        return;
+      }
+
+      // transcript requested from settings, saved as a file
+      if(messageContent.type === 'export') {
+        const blob = new Blob([messageContent.content], { type: messageContent.contentType });
+        const link = document.createElement('a');
+        link.href = URL.createObjectURL(blob);
+        link.download = messageContent.filename;
+        link.click();
+        URL.revokeObjectURL(link.href);
+        return;
+      }
+
+      if(messageContent.type === 'queue') {
+        setQueuePosition(messageContent.position);
+        return;
+      }
+
+      // refusals (lobby full, maintenance, invalid name...) are usually followed by the server closing the socket,
+      // so the reason stays up alongside "Signal Lost"
+      if(messageContent.type === 'error') {
+        setQueuePosition(0);
+        setErrorMessage(messageContent.message);
+        playDenied();
+        return;
+      }
+
+      // someone @mentioned this user (or @here). the message itself arrives as a normal broadcast
+      if(messageContent.type === 'mention') {
+        setMention(messageContent);
+        setTimeout(() => setMention((current) => current === messageContent ? null : current), 5000);
+        playNormal();
+        if(document.hidden && 'Notification' in window && Notification.permission === 'granted') {
+          new Notification(`${messageContent.from} mentioned you in ${messageContent.lobby}`, { body: messageContent.content });
+        }
+        return;
+      }
+
+      // kept to resume this session if the server restarts
+      if(messageContent.type === 'session') {
+        session.current.token = messageContent.token;
+        return;
+      }
+
+      // any other server event (newer frame types) uses a lowercase `type` and isn't a chat message
+      if(messageContent.type) {
+        return;
+      }
+
+      if(messageContent.StreamID) {
+        session.current.cursor = messageContent.StreamID;
+      }
+
       // system messages send either an "arrived" or "departed" type along with the associated user,
-      // add the user to the userList
-      if(messageContent.Type) {
+      // add the user to the userList. imported history is about another lobby's users, so it never changes the list
+      if(messageContent.Type && !messageContent.Imported) {
         // extract the two strings sent on the Type property
         const [action, sentUser] = messageContent.Type;
         // manipulate userList based on user arrival or departure
@@ -269,22 +354,36 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
       setDisconnected(false);
     }
 
-    if (socket.current) {
-      // console.log("WebSocket state in Lobby: ", socket.current.readyState);
-      socket.current.addEventListener('message', handleMessage);
-      socket.current.addEventListener('close', handleSocketClose);
-      socket.current.addEventListener('open', handleSocketOpen);
+    // the socket this run of the effect listens to, socket.current is replaced when App.jsx reconnects
+    const ws = socket.current;
+    if (ws) {
+      // console.log("WebSocket state in Lobby: ", ws.readyState);
+      ws.addEventListener('message', handleMessage);
+      ws.addEventListener('close', handleSocketClose);
+      ws.addEventListener('open', handleSocketOpen);
       // send 'join' action to server in order to receive back an announcement that a user has joined the lobby
-      socket.current.send(JSON.stringify({action: "join", user, lobby}));
+      // the server only reads the directory settings from whoever creates the lobby
+      let settings = action === 'create' ? { public: isPublic, topic, capacity, archive, import: transcript || undefined } : {};
+      if(reconnects > 0) {
+        // rejoining: the resume token keeps the seat and role across a server restart, the cursor asks for only the
+        // messages missed. without a cursor the whole history is sent again
+        settings = { resume: session.current.token || undefined, cursor: session.current.cursor || undefined };
+        if(!session.current.cursor) {
+          setMessageList([]);
+          setUserList([]);
+        }
+        setDisconnected(false);
+      }
+      ws.send(JSON.stringify({action: "join", user, lobby, ...settings}));
 
       return () => {
-        socket.current.removeEventListener('message', handleMessage);
-        socket.current.removeEventListener('close', handleSocketClose);
-        socket.current.removeEventListener('open', handleSocketOpen);
-        socket.current.close(1000, "OK - client closed the application with browser functionality");
+        ws.removeEventListener('message', handleMessage);
+        ws.removeEventListener('close', handleSocketClose);
+        ws.removeEventListener('open', handleSocketOpen);
+        ws.close(1000, "OK - client closed the application with browser functionality");
       };
     }
-  }, [socket]);
+  }, [socket, reconnects]);
 
   return (
     <div className='lobby'>
@@ -312,6 +411,26 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
           </button>
         </div>
       </div>
+      {queuePosition > 0 && (
+        <div className='queue'>
+          <p>Lobby is full. You're number {queuePosition} in line for a seat...</p>
+        </div>
+      )}
+      {banner && (
+        <div className='banner'>
+          <p>{banner}</p>
+        </div>
+      )}
+      {errorMessage && (
+        <div className='banner error-banner' onClick={() => setErrorMessage('')}>
+          <p>{errorMessage}</p>
+        </div>
+      )}
+      {mention && (
+        <div className='banner mention-banner'>
+          <p>{mention.from} mentioned you{mention.here ? ' (@here)' : ''}: {mention.content}</p>
+        </div>
+      )}
       <div className='lobby-content'>
           {showDropdown && (
             <div ref={dropdownRef} className='dropdown'>
@@ -339,9 +458,10 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
               return (
                 <div
                   className={
-                    messageContent.User === 'System' ? 'message system-message'
+                    (messageContent.User === 'System' ? 'message system-message'
                     : messageContent.User === user ? 'message message-cr'
-                    : 'message message-cl'
+                    : 'message message-cl')
+                    + (messageContent.Mentions && messageContent.Mentions.includes(user) ? ' mentioned' : '')
                   }
                   key={index}
                   // assign ref to the newest message in the list
@@ -349,11 +469,14 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
                 >
                   <div className='message-info'>
                     <p className='user' style={{ color: messageContent.Color }}>{messageContent.User}</p>
-                    <p className='time'>{`${messageContent.FormattedTime}`}</p>
-                  </div>
-                  <div className='message-content'>
-                    {messageContent.Content}
+                    <p className='time'>{`${messageContent.Imported ? 'imported · ' : ''}${messageContent.FormattedTime}`}</p>
                   </div>
+                  {/* HTML is the server's sanitized markdown rendering, only sent when messages.render is on */}
+                  {messageContent.HTML
+                    ? <div className='message-content' dangerouslySetInnerHTML={{ __html: messageContent.HTML }} />
+                    : <div className='message-content'>
+                        {messageContent.Content}
+                      </div>}
                 </div>
               );
             })}
@@ -401,7 +524,7 @@ const Lobby = ({ socket, user, userColor, lobby, setLobby, setUser, muted, setMu
       )}
       {settingsModalOpen && (
         <div className='modal-overlay'>
-          <Settings closeModal={() => setSettingsModalOpen(false)} />
+          <Settings closeModal={() => setSettingsModalOpen(false)} exportTranscript={exportTranscript} />
         </div>
       )}      
     </div>
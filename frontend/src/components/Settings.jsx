@@ -1,7 +1,10 @@
-import React from 'react';
+import React, { useState } from 'react';
 import Return from '../images/return.svg'
 
-const Settings = ({ closeModal }) => {
+const Settings = ({ closeModal, exportTranscript }) => {
+  const [exportFormat, setExportFormat] = useState('markdown');
+  const [hideSystem, setHideSystem] = useState(false);
+
   return (
     <div className='settings-modal'>
       <button className='settings-close' onClick={closeModal}>
@@ -16,6 +19,22 @@ const Settings = ({ closeModal }) => {
           - message sounds on/off <br />
         </p> */}
       </div>
+      <div className='settings-export'>
+        <h3>
+          Export
+        </h3>
+        <select value={exportFormat} onChange={(e) => setExportFormat(e.target.value)}>
+          <option value='markdown'>Markdown</option>
+          <option value='text'>Text</option>
+          <option value='html'>HTML</option>
+          <option value='json'>JSON</option>
+        </select>
+        <label>
+          <input type='checkbox' checked={!hideSystem} onChange={(e) => setHideSystem(!e.target.checked)} />
+          arrivals and departures
+        </label>
+        <button onClick={() => exportTranscript(exportFormat, hideSystem)}>DOWNLOAD</button>
+      </div>
       <div className='settings-credits'>
         <h3>
           Credits
@@ -10,6 +10,7 @@ import '../styles/info.scss';
 import { minidenticon } from 'minidenticons';
 import { MinidenticonImg, generateAvatarAndColor, applyShift } from './utils.js';
 import Info from './Info.jsx';
+import Directory from './Directory.jsx';
 import useSound from 'use-sound';
 import Enter from '../sounds/wrgEnter3_short.mp3';
 import Click from '../sounds/mouse-click.mp3';
@@ -24,7 +25,7 @@ import infoSH from '../images/question-mark.svg'
  * @returns {JSX.Element} Rendered Welcome component.
  */
 
-const Welcome = ({ connectWebSocket, loading, setLoading, action, setAction, user, setUser, setUserColor, lobby, setLobby, muted, setMuted, playDenied }) => {
+const Welcome = ({ connectWebSocket, loading, setLoading, action, setAction, isPublic, setIsPublic, topic, setTopic, capacity, setCapacity, archive, setArchive, transcript, setTranscript, user, setUser, setUserColor, lobby, setLobby, muted, setMuted, playDenied }) => {
   const [infoModalOpen, setInfoModalOpen] = useState(false);
   const [playEnter] = useSound(Enter, {volume: muted ? 0: 0.1});
   const [playClick] = useSound(Click, {volume: muted ? 0: 0.2});
@@ -40,6 +41,28 @@ const Welcome = ({ connectWebSocket, loading, setLoading, action, setAction, use
     }
   }
 
+  // picking a lobby from the directory fills it in for joining
+  const selectLobby = (name) => {
+    setLobby(name);
+    setAction('join');
+  }
+
+  // an exported JSON transcript to start the lobby with, the server checks it when the lobby is created
+  const loadTranscript = async (e) => {
+    const file = e.target.files[0];
+    if(!file) {
+      setTranscript(null);
+      return;
+    }
+    try {
+      setTranscript(JSON.parse(await file.text()));
+    } catch (error) {
+      e.target.value = '';
+      setTranscript(null);
+      handleJoinError('Transcript is not valid JSON: ' + error.message);
+    }
+  }
+
   const openInfo = () => {
     setInfoModalOpen(true);
   }
@@ -206,6 +229,57 @@ const Welcome = ({ connectWebSocket, loading, setLoading, action, setAction, use
               </button>
             </div>
           </div>
+          {action === 'create' && (
+            <div className='app-public'>
+              <label className='label-public'>
+                <input
+                  type='checkbox'
+                  checked={isPublic}
+                  onChange={(e) => setIsPublic(e.target.checked)}
+                />
+                public
+              </label>
+              <label className='label-public'>
+                <input
+                  type='checkbox'
+                  checked={archive}
+                  onChange={(e) => setArchive(e.target.checked)}
+                />
+                archive
+              </label>
+              <label className='label-capacity'>
+                max
+                <input
+                  className='app-capacity'
+                  type='number'
+                  min={0}
+                  value={capacity || ''}
+                  onChange={(e) => setCapacity(Math.max(0, parseInt(e.target.value, 10) || 0))}
+                  onKeyDown={handleEnterKeyDown}
+                  placeholder='50'
+                />
+              </label>
+              <label className='label-import'>
+                import
+                <input
+                  className='app-import'
+                  type='file'
+                  accept='.json,application/json'
+                  onChange={loadTranscript}
+                />
+              </label>
+              {isPublic && (
+                <input
+                  className='app-topic'
+                  value={topic}
+                  onChange={(e) => setTopic(e.target.value.trimStart())}
+                  onKeyDown={handleEnterKeyDown}
+                  placeholder='topic'
+                  maxLength={80}
+                />
+              )}
+            </div>
+          )}
           <div className='app-enter-container'>
             { loading ? (
               <div className={`lds-ellipsis` + `${action === 'join' ? ' lj' : ' lc'}`}><div></div><div></div><div></div><div></div></div>
@@ -220,6 +294,7 @@ const Welcome = ({ connectWebSocket, loading, setLoading, action, setAction, use
             </button>
           </div>
         </div>
+        <Directory selectLobby={selectLobby} />
       </div>
     </div>
   )
@@ -32,6 +32,14 @@ export function generateAvatarAndColor(username, saturation = '90', lightness =
   return { avatar: generatedAvatar, color: extractedColor };
 }
 
+// escapes text for use as HTML, so a message without a server rendering can be grouped with one that has it
+const escapeHTML = (text) => text
+  .replace(/&/g, '&amp;')
+  .replace(/</g, '&lt;')
+  .replace(/>/g, '&gt;')
+  .replace(/"/g, '&quot;')
+  .replace(/'/g, '&#39;');
+
 /**
  * Groups messages based on timestamp.
  * @param {string} newMessage Message content to be added to and grouped within the message list (must be parsed first if received from the server).
@@ -54,12 +62,18 @@ export const groupMessages = (newMessage, messageList) => {
       return [...messageList, newMessage];
     } else {
       // messages have been sent within the same minute, only update messageContent.Content for the current message
+      const grouped = {
+        ...lastMessage,
+        Content: `${lastMessage.Content}\n${newMessage.Content}`,
+        Mentions: [...(lastMessage.Mentions || []), ...(newMessage.Mentions || [])],
+      };
+      // rendered messages (messages.render) are grouped as HTML too, falling back to escaped text for either half
+      if(lastMessage.HTML || newMessage.HTML) {
+        grouped.HTML = `${lastMessage.HTML || escapeHTML(lastMessage.Content)}<br>${newMessage.HTML || escapeHTML(newMessage.Content)}`;
+      }
       return [
         ...messageList.slice(0, messageList.length - 1),
-        {
-          ...lastMessage,
-          Content: `${lastMessage.Content}\n${newMessage.Content}`,
-        },
+        grouped,
       ];
     }
   } else {